@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// AIS message types sent for the targets
+const (
+	aisPositionReportClassA = 1
+	aisPositionReportClassB = 18
+	aisStaticDataReport     = 24
+)
+
+// Values for data not available in AIS position reports
+const (
+	aisLonNA       = 181
+	aisLatNA       = 91
+	aisSogNA       = 1023
+	aisCogNA       = 3600
+	aisHeadingNA   = 511
+	aisTimestampNA = 60
+	aisRotNA       = -128
+	// aisNavStatusNA is navigational status not defined
+	aisNavStatusNA = 15
+)
+
+// aisShipTypeUnderwaterOps is the ship type "engaged in dredging or underwater operations"
+const aisShipTypeUnderwaterOps = 33
+
+// aisMaxMMSI is the largest MMSI, it has 9 digits
+const aisMaxMMSI = 999999999
+
+// aisBits builds the binary payload of an AIS message, most significant bit first
+type aisBits struct {
+	bits []byte
+}
+
+// uint appends the n lowest bits of v
+func (b *aisBits) uint(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.bits = append(b.bits, byte(v>>i)&1)
+	}
+}
+
+// int appends v as a n bit two's complement number
+func (b *aisBits) int(v int64, n int) {
+	b.uint(uint64(v), n)
+}
+
+// text appends s as chars characters of 6-bit ASCII padded with @.
+// Lower case is converted to upper case and characters which can not be encoded are sent as ?.
+func (b *aisBits) text(s string, chars int) {
+	runes := []rune(strings.ToUpper(s))
+	for i := 0; i < chars; i++ {
+		c := '@'
+		if i < len(runes) {
+			c = runes[i]
+		}
+		switch {
+		case c >= 64 && c < 96:
+			b.uint(uint64(c-64), 6)
+		case c >= 32 && c < 64:
+			b.uint(uint64(c), 6)
+		default:
+			b.uint('?', 6)
+		}
+	}
+}
+
+// armor returns the payload in 6-bit ASCII armoring and the number of fill bits added
+// to make the payload a multiple of 6 bits
+func armor(bits []byte) (string, int) {
+	fill := (6 - len(bits)%6) % 6
+	var sb strings.Builder
+	for i := 0; i < len(bits); i += 6 {
+		var v byte
+		for j := i; j < i+6; j++ {
+			v <<= 1
+			if j < len(bits) {
+				v |= bits[j]
+			}
+		}
+		v += 48
+		if v > 87 {
+			v += 8
+		}
+		sb.WriteByte(v)
+	}
+	return sb.String(), fill
+}
+
+// aisScaled returns value/resolution rounded, or notAvailable if it is not in the range [0, max]
+func aisScaled(value, resolution float64, max uint64, notAvailable uint64) uint64 {
+	v := math.Round(value / resolution)
+	if math.IsNaN(v) || v < 0 || v > float64(max) {
+		return notAvailable
+	}
+	return uint64(v)
+}
+
+// aisPosition is the data in a position report. NaN is used for data not available.
+type aisPosition struct {
+	mmsi int
+	lat  float64
+	lon  float64
+	// cog in degrees true and sog in knots
+	cog  float64
+	sog  float64
+	time time.Time
+}
+
+// positionData appends the speed, position, course, heading and time stamp
+// which are the same in class A and class B position reports
+func (b *aisBits) positionData(pos aisPosition) {
+	lon, lat := pos.lon, pos.lat
+	if math.IsNaN(lon) || math.IsNaN(lat) {
+		lon, lat = aisLonNA, aisLatNA
+	}
+	b.uint(aisScaled(pos.sog, 0.1, 1022, aisSogNA), 10)
+	// Position accuracy low (> 10 m)
+	b.uint(0, 1)
+	// Position in 1/10000 minutes
+	b.int(int64(math.Round(lon*600000)), 28)
+	b.int(int64(math.Round(lat*600000)), 27)
+	b.uint(aisScaled(pos.cog, 0.1, 3599, aisCogNA), 12)
+	b.uint(aisHeadingNA, 9)
+	timestamp := uint64(aisTimestampNA)
+	if !pos.time.IsZero() {
+		timestamp = uint64(pos.time.UTC().Second())
+	}
+	b.uint(timestamp, 6)
+}
+
+// aisPositionReport encodes a class A position report (message 1) or a class B position report (message 18)
+func aisPositionReport(messageType int, pos aisPosition) []byte {
+	var b aisBits
+	b.uint(uint64(messageType), 6)
+	// Repeat indicator
+	b.uint(0, 2)
+	b.uint(uint64(pos.mmsi), 30)
+	if messageType == aisPositionReportClassA {
+		b.uint(aisNavStatusNA, 4)
+		b.int(aisRotNA, 8)
+		b.positionData(pos)
+		// No special manoeuvre, spare, RAIM not in use, radio status
+		b.uint(0, 2)
+		b.uint(0, 3)
+		b.uint(0, 1)
+		b.uint(0, 19)
+		return b.bits
+	}
+	// Reserved
+	b.uint(0, 8)
+	b.positionData(pos)
+	// Regional reserved
+	b.uint(0, 2)
+	// Carrier sense unit, no display, no DSC
+	b.uint(1, 1)
+	b.uint(0, 1)
+	b.uint(0, 1)
+	// Whole marine band, no message 22, autonomous mode, RAIM not in use
+	b.uint(1, 1)
+	b.uint(0, 1)
+	b.uint(0, 1)
+	b.uint(0, 1)
+	// Radio status
+	b.uint(0, 20)
+	return b.bits
+}
+
+// aisStaticDataA encodes part A of the static data report (message 24) with the name
+func aisStaticDataA(mmsi int, name string) []byte {
+	var b aisBits
+	b.uint(aisStaticDataReport, 6)
+	b.uint(0, 2)
+	b.uint(uint64(mmsi), 30)
+	// Part number
+	b.uint(0, 2)
+	b.text(name, 20)
+	// Spare
+	b.uint(0, 8)
+	return b.bits
+}
+
+// aisStaticDataB encodes part B of the static data report (message 24) with the ship type.
+// Vendor, call sign and dimensions are not available.
+func aisStaticDataB(mmsi int) []byte {
+	var b aisBits
+	b.uint(aisStaticDataReport, 6)
+	b.uint(0, 2)
+	b.uint(uint64(mmsi), 30)
+	b.uint(1, 2)
+	b.uint(aisShipTypeUnderwaterOps, 8)
+	// Vendor ID, model and serial
+	b.text("", 3)
+	b.uint(0, 4)
+	b.uint(0, 20)
+	// Call sign
+	b.text("", 7)
+	// Dimensions to bow, stern, port and starboard
+	b.uint(0, 9)
+	b.uint(0, 9)
+	b.uint(0, 6)
+	b.uint(0, 6)
+	// Spare
+	b.uint(0, 6)
+	return b.bits
+}
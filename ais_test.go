@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aisField returns n bits from start as an unsigned number
+func aisField(bits []byte, start, n int) uint64 {
+	var v uint64
+	for _, bit := range bits[start : start+n] {
+		v = v<<1 | uint64(bit)
+	}
+	return v
+}
+
+// aisSignedField returns n bits from start as a two's complement number
+func aisSignedField(bits []byte, start, n int) int64 {
+	return int64(aisField(bits, start, n)<<(64-n)) >> (64 - n)
+}
+
+// aisText returns chars characters of 6-bit ASCII from start
+func aisText(bits []byte, start, chars int) string {
+	var sb strings.Builder
+	for i := 0; i < chars; i++ {
+		c := byte(aisField(bits, start+6*i, 6))
+		if c < 32 {
+			c += 64
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// parseVDM parses a VDM or VDO sentence and returns the payload bits
+func parseVDM(t *testing.T, line string) nmea.VDMVDO {
+	sentence, err := nmea.Parse(line)
+	require.NoError(t, err, line)
+	return sentence.(nmea.VDMVDO)
+}
+
+func TestAISArmor(t *testing.T) {
+	// Example from https://gpsd.gitlab.io/gpsd/AIVDM.html
+	vdm := parseVDM(t, "!AIVDM,1,1,,B,177KQJ5000G?tO`K>RA1wUbN0TKH,0*5C")
+	payload, fill := armor(vdm.Payload)
+	assert.Equal(t, "177KQJ5000G?tO`K>RA1wUbN0TKH", payload)
+	assert.Equal(t, 0, fill)
+
+	// Fill bits pad the last character
+	payload, fill = armor([]byte{1, 1, 1, 1, 1, 1, 1})
+	assert.Equal(t, "wP", payload)
+	assert.Equal(t, 5, fill)
+
+	var b aisBits
+	b.text("Rov-1 ä", 8)
+	assert.Equal(t, "ROV-1 ?@", aisText(b.bits, 0, 8))
+}
+
+func TestAISPositionReport(t *testing.T) {
+	pos := aisPosition{mmsi: 970123456, lat: 63.4, lon: -10.4, cog: 123.4, sog: 1.5, time: time.Date(2024, 5, 1, 12, 30, 17, 0, time.UTC)}
+
+	bits := aisPositionReport(aisPositionReportClassB, pos)
+	require.Len(t, bits, 168)
+	assert.Equal(t, uint64(18), aisField(bits, 0, 6))
+	assert.Equal(t, uint64(970123456), aisField(bits, 8, 30))
+	assert.Equal(t, uint64(15), aisField(bits, 46, 10))
+	assert.Equal(t, int64(-6240000), aisSignedField(bits, 57, 28))
+	assert.Equal(t, int64(38040000), aisSignedField(bits, 85, 27))
+	assert.Equal(t, uint64(1234), aisField(bits, 112, 12))
+	assert.Equal(t, uint64(aisHeadingNA), aisField(bits, 124, 9))
+	assert.Equal(t, uint64(17), aisField(bits, 133, 6))
+	// Carrier sense unit
+	assert.Equal(t, uint64(1), aisField(bits, 141, 1))
+
+	bits = aisPositionReport(aisPositionReportClassA, pos)
+	require.Len(t, bits, 168)
+	assert.Equal(t, uint64(1), aisField(bits, 0, 6))
+	assert.Equal(t, uint64(970123456), aisField(bits, 8, 30))
+	assert.Equal(t, uint64(aisNavStatusNA), aisField(bits, 38, 4))
+	assert.Equal(t, int64(aisRotNA), aisSignedField(bits, 42, 8))
+	assert.Equal(t, uint64(15), aisField(bits, 50, 10))
+	assert.Equal(t, int64(-6240000), aisSignedField(bits, 61, 28))
+	assert.Equal(t, int64(38040000), aisSignedField(bits, 89, 27))
+	assert.Equal(t, uint64(1234), aisField(bits, 116, 12))
+	assert.Equal(t, uint64(17), aisField(bits, 137, 6))
+
+	// Data not available
+	bits = aisPositionReport(aisPositionReportClassB, aisPosition{mmsi: 970123456, lat: math.NaN(), lon: math.NaN(), cog: math.NaN(), sog: math.NaN()})
+	assert.Equal(t, uint64(aisSogNA), aisField(bits, 46, 10))
+	assert.Equal(t, int64(aisLonNA*600000), aisSignedField(bits, 57, 28))
+	assert.Equal(t, int64(aisLatNA*600000), aisSignedField(bits, 85, 27))
+	assert.Equal(t, uint64(aisCogNA), aisField(bits, 112, 12))
+	assert.Equal(t, uint64(aisTimestampNA), aisField(bits, 133, 6))
+}
+
+func TestAISStaticData(t *testing.T) {
+	bits := aisStaticDataA(970123456, "ROV")
+	require.Len(t, bits, 168)
+	assert.Equal(t, uint64(24), aisField(bits, 0, 6))
+	assert.Equal(t, uint64(970123456), aisField(bits, 8, 30))
+	assert.Equal(t, uint64(0), aisField(bits, 38, 2))
+	assert.Equal(t, "ROV@@@@@@@@@@@@@@@@@", aisText(bits, 40, 20))
+
+	bits = aisStaticDataB(970123456)
+	require.Len(t, bits, 168)
+	assert.Equal(t, uint64(24), aisField(bits, 0, 6))
+	assert.Equal(t, uint64(1), aisField(bits, 38, 2))
+	assert.Equal(t, uint64(aisShipTypeUnderwaterOps), aisField(bits, 40, 8))
+}
+
+func TestAIVDM(t *testing.T) {
+	bits := aisStaticDataA(970123456, "ROV")
+	line := AIVDM{Sentence: "VDM", Channel: "A", Payload: bits}.Serialise()
+	assert.True(t, strings.HasPrefix(line, "!AIVDM,1,1,,A,"), line)
+	vdm := parseVDM(t, line)
+	assert.Equal(t, bits, vdm.Payload)
+
+	// Messages longer than one sentence are split
+	long := append(append([]byte{}, bits...), bits...)
+	long = append(long, bits...)
+	lines := strings.Split(AIVDM{Talker: "BS", Sentence: "VDO", MessageID: 3, Payload: long}.Serialise(), "\r\n")
+	require.Len(t, lines, 2)
+	first, second := parseVDM(t, lines[0]), parseVDM(t, lines[1])
+	assert.Equal(t, "BSVDO", first.Prefix())
+	assert.Equal(t, int64(2), first.NumFragments)
+	assert.Equal(t, int64(2), second.FragmentNumber)
+	assert.Equal(t, int64(3), second.MessageID)
+	assert.Equal(t, long, append(first.Payload, second.Payload...))
+}
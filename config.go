@@ -3,23 +3,113 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Input struct {
-		Device          string `yaml:"device"`
-		HeadingSentence string `yaml:"heading_sentence"`
-		Retransmit      string `yaml:"retransmit"`
+		Device string `yaml:"device"`
+		// Format is nmea0183 (default), or ydraw or actisense-ascii for NMEA 2000 gateways
+		Format           string   `yaml:"format"`
+		PositionSentence string   `yaml:"position_sentence"`
+		HeadingSentence  string   `yaml:"heading_sentence"`
+		HeadingSentences []string `yaml:"heading_sentences"`
+		HeadingTimeout   float64  `yaml:"heading_timeout"`
+		HeadingOffset    float64  `yaml:"heading_offset"`
+		LeverArm         leverArm `yaml:"lever_arm"`
+		Magnetic         struct {
+			Declination float64 `yaml:"declination"`
+			WMM         bool    `yaml:"wmm"`
+		} `yaml:"magnetic"`
+		Retransmit string `yaml:"retransmit"`
+		Depth      struct {
+			Sentence string  `yaml:"sentence"`
+			Offset   float64 `yaml:"offset"`
+			XDRName  string  `yaml:"xdr_name"`
+		} `yaml:"depth"`
 	} `yaml:"input"`
-	Output struct {
-		Device           string `yaml:"device"`
-		PositionSentence string `yaml:"position_sentence"`
-	} `yaml:"output"`
+	Output outputConfigs `yaml:"output"`
+	// Targets are sent as tracked targets in tll and ttm sentences, the Locator if empty
+	Targets []TargetConfig `yaml:"targets"`
+	AIS     struct {
+		// MessageType is the AIS position report, 1 (class A) or 18 (class B, default)
+		MessageType int `yaml:"message_type"`
+	} `yaml:"ais"`
 	BaseURL string `yaml:"ugps_url"`
 }
 
+// TargetConfig is a position from the UGPS sent as a tracked target
+type TargetConfig struct {
+	// Endpoint is locator, master/topside or the path of a UGPS position endpoint
+	Endpoint string `yaml:"endpoint"`
+	Number   int    `yaml:"number"`
+	Name     string `yaml:"name"`
+	// MMSI identifies the target in AIS output
+	MMSI int `yaml:"mmsi"`
+}
+
+// formatNMEA0183 is the default output format
+const formatNMEA0183 = "nmea0183"
+
+// OutputConfig is a destination for the Locator position
+type OutputConfig struct {
+	Device string `yaml:"device"`
+	// PositionSentence is used if Sentences is empty
+	PositionSentence string   `yaml:"position_sentence"`
+	Sentences        []string `yaml:"sentences"`
+	// Talker is the talker ID of the sentences, the default of each sentence is used if empty
+	Talker string `yaml:"talker"`
+	// Format is nmea0183 (default), or ydraw or actisense for NMEA 2000 where sentences are PGNs
+	Format string `yaml:"format"`
+	// Rate is the maximum number of positions per second, 0 to send every new position
+	Rate float64 `yaml:"rate"`
+}
+
+// defaultN2KPGNs are sent to NMEA 2000 outputs if no sentences are configured
+var defaultN2KPGNs = []string{"129025", "129029"}
+
+// IsN2K returns true if the output is NMEA 2000
+func (o OutputConfig) IsN2K() bool {
+	_, exists := n2kEncodings[strings.ToLower(o.Format)]
+	return exists || strings.HasPrefix(o.Device, schemeSocketCAN+"://")
+}
+
+// PositionSentences returns the sentences, or PGNs for NMEA 2000, to send for each position
+func (o OutputConfig) PositionSentences() []string {
+	if len(o.Sentences) > 0 {
+		return o.Sentences
+	}
+	if o.PositionSentence != "" {
+		return []string{o.PositionSentence}
+	}
+	if o.IsN2K() {
+		return defaultN2KPGNs
+	}
+	return nil
+}
+
+// outputConfigs is a list of outputs. A single output can be given without a list.
+type outputConfigs []OutputConfig
+
+func (o *outputConfigs) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var single OutputConfig
+		if err := value.Decode(&single); err != nil {
+			return err
+		}
+		*o = outputConfigs{single}
+		return nil
+	}
+	var list []OutputConfig
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*o = list
+	return nil
+}
+
 func readFile(cfg *Config, filename string) error {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -35,14 +125,52 @@ func readFile(cfg *Config, filename string) error {
 	return nil
 }
 
+// HeadingSources returns the heading sentences in order of priority.
+// heading_sentences takes precedence over heading_sentence, which can be a comma separated list.
+func (c Config) HeadingSources() []string {
+	sources := c.Input.HeadingSentences
+	if len(sources) == 0 {
+		sources = strings.Split(c.Input.HeadingSentence, ",")
+	}
+	trimmed := make([]string, 0, len(sources))
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		if source != "" {
+			trimmed = append(trimmed, source)
+		}
+	}
+	return trimmed
+}
+
 func (c Config) InputEnabled() bool {
 	return c.Input.Device != ""
 }
 
+// InputIsN2K returns true if the input is NMEA 2000
+func (c Config) InputIsN2K() bool {
+	_, exists := n2kInputFormats[strings.ToLower(c.Input.Format)]
+	return exists || strings.HasPrefix(c.Input.Device, schemeSocketCAN+"://")
+}
+
 func (c Config) RetransmitEnabled() bool {
 	return c.Input.Retransmit != ""
 }
 
+func (c Config) DepthEnabled() bool {
+	return c.InputEnabled() && c.Input.Depth.Sentence != ""
+}
+
 func (c Config) OutputEnabled() bool {
-	return c.Output.Device != ""
+	return len(c.EnabledOutputs()) > 0
+}
+
+// EnabledOutputs returns the outputs with a device
+func (c Config) EnabledOutputs() []OutputConfig {
+	var enabled []OutputConfig
+	for _, output := range c.Output {
+		if output.Device != "" {
+			enabled = append(enabled, output)
+		}
+	}
+	return enabled
 }
@@ -12,6 +12,7 @@ func TestConfig(t *testing.T) {
 
 	assert.False(t, cfg.InputEnabled())
 	assert.False(t, cfg.OutputEnabled())
+	assert.False(t, cfg.DepthEnabled())
 
 	err := readFile(&cfg, "does-not-exist")
 
@@ -19,7 +20,16 @@ func TestConfig(t *testing.T) {
 
 	data := `input:
   device: /dev/ttyUSB0
+  position_sentence: gns
   heading_sentence: hdm
+  heading_offset: 1.5
+  lever_arm:
+    x: 2.0
+    y: -1.0
+  depth:
+    sentence: xdr
+    offset: -0.5
+    xdr_name: DEPTH
 output:
   device: /dev/ttyUSB1@9600
   position_sentence: gpgga
@@ -34,15 +44,104 @@ ugps_url: http://127.0.0.1:8080
 	err = readFile(&cfg, fn)
 	assert.NoError(t, err)
 	assert.Equal(t, "/dev/ttyUSB0", cfg.Input.Device)
+	assert.Equal(t, "gns", cfg.Input.PositionSentence)
 	assert.Equal(t, "hdm", cfg.Input.HeadingSentence)
+	assert.Equal(t, 1.5, cfg.Input.HeadingOffset)
+	assert.Equal(t, leverArm{X: 2.0, Y: -1.0}, cfg.Input.LeverArm)
+	assert.Equal(t, "xdr", cfg.Input.Depth.Sentence)
+	assert.Equal(t, -0.5, cfg.Input.Depth.Offset)
+	assert.Equal(t, "DEPTH", cfg.Input.Depth.XDRName)
+	assert.True(t, cfg.DepthEnabled())
 
-	assert.Equal(t, "/dev/ttyUSB1@9600", cfg.Output.Device)
-	assert.Equal(t, "gpgga", cfg.Output.PositionSentence)
+	assert.Len(t, cfg.Output, 1)
+	assert.Equal(t, "/dev/ttyUSB1@9600", cfg.Output[0].Device)
+	assert.Equal(t, []string{"gpgga"}, cfg.Output[0].PositionSentences())
 
 	assert.Equal(t, "http://127.0.0.1:8080", cfg.BaseURL)
 
 	assert.True(t, cfg.InputEnabled())
 	assert.True(t, cfg.OutputEnabled())
+	assert.Equal(t, []string{"hdm"}, cfg.HeadingSources())
+}
+
+func TestConfigHeadingSources(t *testing.T) {
+	cfg := Config{}
+	assert.Empty(t, cfg.HeadingSources())
+
+	cfg.Input.HeadingSentence = "ths, hdm"
+	assert.Equal(t, []string{"ths", "hdm"}, cfg.HeadingSources())
+
+	cfg.Input.HeadingSentences = []string{"hdt", "hdg"}
+	assert.Equal(t, []string{"hdt", "hdg"}, cfg.HeadingSources())
+}
+
+func TestConfigInputIsN2K(t *testing.T) {
+	cfg := Config{}
+	cfg.Input.Device = "COM1@4800"
+	assert.False(t, cfg.InputIsN2K())
+
+	cfg.Input.Format = "nmea0183"
+	assert.False(t, cfg.InputIsN2K())
+
+	cfg.Input.Format = "YDRAW"
+	assert.True(t, cfg.InputIsN2K())
+
+	cfg.Input.Device = "socketcan://can0"
+	cfg.Input.Format = ""
+	assert.True(t, cfg.InputIsN2K())
+}
+
+func TestConfigOutputList(t *testing.T) {
+	cfg := Config{}
+
+	data := `output:
+  - device: COM1@4800
+    position_sentence: ratll
+  - device: ""
+  - device: udp://127.0.0.1:2947
+    sentences: [gpgga, ratll]
+    talker: GN
+    rate: 1
+  - device: socketcan://can0
+  - device: udp://127.0.0.1:2948
+    format: ydraw
+    sentences: [129025, 128267]
+targets:
+  - endpoint: locator
+    number: 1
+    name: ROV
+    mmsi: 970123456
+  - endpoint: topside
+    number: 2
+    name: Boat
+ais:
+  message_type: 1
+`
+	fn := "/tmp/config.yml.3"
+	err := os.WriteFile(fn, []byte(data), 0644)
+	assert.NoError(t, err)
+
+	defer os.Remove(fn)
+
+	err = readFile(&cfg, fn)
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Output, 5)
+	assert.True(t, cfg.OutputEnabled())
+
+	outputs := cfg.EnabledOutputs()
+	assert.Len(t, outputs, 4)
+	assert.Equal(t, []string{"ratll"}, outputs[0].PositionSentences())
+	assert.Equal(t, []string{"gpgga", "ratll"}, outputs[1].PositionSentences())
+	assert.Equal(t, 1.0, outputs[1].Rate)
+	assert.Empty(t, outputs[0].Talker)
+	assert.Equal(t, "GN", outputs[1].Talker)
+	assert.False(t, outputs[1].IsN2K())
+	assert.True(t, outputs[2].IsN2K())
+	assert.Equal(t, []string{"129025", "129029"}, outputs[2].PositionSentences())
+	assert.True(t, outputs[3].IsN2K())
+	assert.Equal(t, []string{"129025", "128267"}, outputs[3].PositionSentences())
+	assert.Equal(t, []TargetConfig{{Endpoint: "locator", Number: 1, Name: "ROV", MMSI: 970123456}, {Endpoint: "topside", Number: 2, Name: "Boat"}}, cfg.Targets)
+	assert.Equal(t, 1, cfg.AIS.MessageType)
 }
 
 func TestConfigInvalid(t *testing.T) {
@@ -66,7 +165,9 @@ func TestExampleConfig(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, cfg.Input.Device)
 	assert.NotEmpty(t, cfg.Input.HeadingSentence)
-	assert.NotEmpty(t, cfg.Output.Device)
-	assert.NotEmpty(t, cfg.Output.PositionSentence)
+	assert.NotEmpty(t, cfg.EnabledOutputs())
+	for _, output := range cfg.EnabledOutputs() {
+		assert.NotEmpty(t, output.PositionSentences())
+	}
 	assert.NotEmpty(t, cfg.BaseURL)
 }
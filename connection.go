@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// reconnectMinDelay is the time to wait before reopening a device after the first failure
+	reconnectMinDelay = 1 * time.Second
+	// reconnectMaxDelay is the maximum time to wait before reopening a device which keeps failing
+	reconnectMaxDelay = 10 * time.Second
+)
+
+// connectionWriteTimeout is the maximum time a write to a network connection can block
+const connectionWriteTimeout = 1 * time.Second
+
+// connectionStatus is the status of a device which is reopened when it fails
+type connectionStatus struct {
+	connected  bool
+	reconnects int
+	errMsg     string
+	// listening is true for a TCP server, clients is the number of connected clients
+	listening bool
+	clients   int
+}
+
+func (s connectionStatus) String() string {
+	if s.listening {
+		// The accept error is not reported elsewhere for outputs
+		if s.errMsg != "" {
+			return fmt.Sprintf("listening, clients: %d, %s", s.clients, s.errMsg)
+		}
+		return fmt.Sprintf("listening, clients: %d", s.clients)
+	}
+	state := "disconnected"
+	if s.connected {
+		state = "connected"
+	}
+	return fmt.Sprintf("%s, reconnects: %d", state, s.reconnects)
+}
+
+// connectionStatusReporter is implemented by devices which report their connection status
+type connectionStatusReporter interface {
+	connectionStatus() connectionStatus
+}
+
+// listenerStatus is the status of a TCP server reading from the clients which connect to it
+type listenerStatus struct {
+	mu        sync.Mutex
+	clients   int
+	acceptErr string
+}
+
+// accepted counts a new client and clears the accept error
+func (s *listenerStatus) accepted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients++
+	s.acceptErr = ""
+}
+
+// disconnected counts a client which disconnected
+func (s *listenerStatus) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients--
+}
+
+// acceptFailed records the error accepting clients
+func (s *listenerStatus) acceptFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceptErr = err.Error()
+}
+
+func (s *listenerStatus) connectionStatus() connectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return connectionStatus{listening: true, clients: s.clients, errMsg: s.acceptErr}
+}
+
+// reconnectingConn opens a device when it is used and reopens it if it fails,
+// eg. when a USB serial adapter is unplugged or a socket fails.
+// The delay before reopening is doubled for each failed attempt.
+type reconnectingConn struct {
+	name string
+	open func() (io.ReadWriteCloser, error)
+
+	mu     sync.Mutex
+	conn   io.ReadWriteCloser
+	opened bool
+	// opening is true while open is called without holding mu
+	opening bool
+	closed  bool
+	retryAt time.Time
+	delay   time.Duration
+	status  connectionStatus
+}
+
+// newReconnectingConn creates a connection named name which is opened using open
+func newReconnectingConn(name string, open func() (io.ReadWriteCloser, error)) *reconnectingConn {
+	return &reconnectingConn{name: name, open: open}
+}
+
+// get returns the open connection. It is opened if the retry delay has passed.
+func (c *reconnectingConn) get() (io.ReadWriteCloser, error) {
+	c.mu.Lock()
+	conn, err := c.current()
+	if conn != nil || err != nil {
+		c.mu.Unlock()
+		return conn, err
+	}
+	// Open without holding mu, so Close and connectionStatus do not wait for a TCP dial or USB enumeration
+	c.opening = true
+	c.mu.Unlock()
+
+	conn, err = c.open()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opening = false
+	if c.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, net.ErrClosed
+	}
+	if err != nil {
+		c.failed(err)
+		return nil, err
+	}
+	if c.opened {
+		c.status.reconnects++
+		debugPrintf("Reconnected to %s", c.name)
+	}
+	c.opened = true
+	c.conn = conn
+	c.delay = 0
+	c.status.connected = true
+	c.status.errMsg = ""
+	return conn, nil
+}
+
+// current returns the open connection, or an error if it can not be opened now.
+// Both are nil if it should be opened. mu must be held.
+func (c *reconnectingConn) current() (io.ReadWriteCloser, error) {
+	if c.closed {
+		return nil, net.ErrClosed
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if c.opening {
+		return nil, fmt.Errorf("connecting to %s", c.name)
+	}
+	if time.Now().Before(c.retryAt) {
+		return nil, fmt.Errorf("not connected to %s: %s", c.name, c.status.errMsg)
+	}
+	return nil, nil
+}
+
+// nextReconnectDelay returns the delay before the next attempt after an attempt which waited delay
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return reconnectMinDelay
+	}
+	return min(2*delay, reconnectMaxDelay)
+}
+
+// failed schedules the next attempt to open. mu must be held.
+func (c *reconnectingConn) failed(err error) {
+	c.delay = nextReconnectDelay(c.delay)
+	c.retryAt = time.Now().Add(c.delay)
+	c.status.connected = false
+	c.status.errMsg = err.Error()
+	debugPrintf("%s failed: %v. Retrying in %v", c.name, err, c.delay)
+}
+
+// fail closes conn after it failed with err. It is reopened after the retry delay.
+func (c *reconnectingConn) fail(conn io.ReadWriteCloser, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn != c.conn {
+		// Already failed by another user of the connection
+		return
+	}
+	conn.Close()
+	c.conn = nil
+	if !c.closed {
+		c.failed(err)
+	}
+}
+
+// wait returns the time until the next attempt to open
+func (c *reconnectingConn) wait() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opening {
+		// Another user is opening it
+		return reconnectMinDelay
+	}
+	return max(time.Until(c.retryAt), 0)
+}
+
+func (c *reconnectingConn) Write(p []byte) (int, error) {
+	conn, err := c.get()
+	if err != nil {
+		return 0, err
+	}
+	if deadliner, ok := conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		deadliner.SetWriteDeadline(time.Now().Add(connectionWriteTimeout))
+	}
+	n, err := conn.Write(p)
+	// UDP reports a refused connection if nobody is listening, but the socket can still be used
+	if err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+		c.fail(conn, err)
+	}
+	return n, err
+}
+
+// Close closes the connection and stops reopening it
+func (c *reconnectingConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.status.connected = false
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *reconnectingConn) connectionStatus() connectionStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn fails writing when broken is true
+type fakeConn struct {
+	broken bool
+	closed bool
+}
+
+func (c *fakeConn) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	if c.broken {
+		return 0, errors.New("device unplugged")
+	}
+	return len(p), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestReconnectingConn(t *testing.T) {
+	var opened []*fakeConn
+	openErr := errors.New("no such device")
+	available := true
+	c := newReconnectingConn("COM1", func() (io.ReadWriteCloser, error) {
+		if !available {
+			return nil, openErr
+		}
+		conn := &fakeConn{}
+		opened = append(opened, conn)
+		return conn, nil
+	})
+
+	_, err := c.Write([]byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, connectionStatus{connected: true}, c.connectionStatus())
+
+	// The device fails and is closed
+	opened[0].broken = true
+	available = false
+	_, err = c.Write([]byte("2"))
+	require.Error(t, err)
+	assert.True(t, opened[0].closed)
+	assert.Equal(t, connectionStatus{errMsg: "device unplugged"}, c.connectionStatus())
+
+	// Not reopened before the delay
+	_, err = c.Write([]byte("3"))
+	require.Error(t, err)
+	assert.Len(t, opened, 1)
+	assert.InDelta(t, reconnectMinDelay.Seconds(), c.wait().Seconds(), 0.1)
+
+	// Reopening fails and the delay is doubled
+	c.retryAt = time.Now()
+	_, err = c.Write([]byte("4"))
+	assert.Equal(t, openErr, err)
+	assert.Equal(t, 2*reconnectMinDelay, c.delay)
+
+	// Reopened
+	available = true
+	c.retryAt = time.Now()
+	_, err = c.Write([]byte("5"))
+	require.NoError(t, err)
+	assert.Len(t, opened, 2)
+	assert.Equal(t, connectionStatus{connected: true, reconnects: 1}, c.connectionStatus())
+	assert.Equal(t, time.Duration(0), c.delay)
+
+	require.NoError(t, c.Close())
+	assert.True(t, opened[1].closed)
+	_, err = c.Write([]byte("6"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestReconnectingConnCloseWhileOpening(t *testing.T) {
+	opening := make(chan struct{})
+	release := make(chan struct{})
+	conn := &fakeConn{}
+	c := newReconnectingConn("tcp://192.168.2.10:10110", func() (io.ReadWriteCloser, error) {
+		close(opening)
+		<-release
+		return conn, nil
+	})
+
+	result := make(chan error)
+	go func() {
+		_, err := c.Write([]byte("1"))
+		result <- err
+	}()
+	<-opening
+
+	// Other users do not wait for the slow open
+	_, err := c.get()
+	assert.Error(t, err)
+	assert.Equal(t, reconnectMinDelay, c.wait())
+	assert.Equal(t, connectionStatus{}, c.connectionStatus())
+	require.NoError(t, c.Close())
+
+	// The connection opened after Close is closed
+	close(release)
+	assert.ErrorIs(t, <-result, net.ErrClosed)
+	assert.True(t, conn.closed)
+}
@@ -7,6 +7,8 @@ require (
 	github.com/gizak/termui/v3 v3.1.0
 	github.com/stretchr/testify v1.9.0
 	go.bug.st/serial v1.6.2
+	golang.org/x/net v0.30.0
+	golang.org/x/sys v0.26.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -18,5 +20,4 @@ require (
 	github.com/nsf/termbox-go v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/sys v0.26.0 // indirect
 )
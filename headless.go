@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// headlessLogInterval is how often a status summary is logged in headless mode
+const headlessLogInterval = 10 * time.Second
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func setupHeadlessLogger() {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+// RunHeadless logs the status of input and output until the context is cancelled
+func RunHeadless(ctx context.Context, cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outputStats, cfgSource string) {
+	outputs := cfg.EnabledOutputs()
+	outputDevices := make([]string, len(outputs))
+	for i, output := range outputs {
+		outputDevices[i] = output.Device
+	}
+	logger.Info("starting", "app", applicationName(), "config", cfgSource,
+		"input", cfg.Input.Device, "output", strings.Join(outputDevices, ", "), "ugps_url", cfg.BaseURL)
+
+	var (
+		inStats    inputStats
+		outStats   outputStats
+		gotInput   bool
+		gotOutput  bool
+		lastErrors = make(map[string]string)
+	)
+
+	// logChangedError logs an error message once when it appears and once when it is cleared
+	logChangedError := func(source string, message string) {
+		if lastErrors[source] == message {
+			return
+		}
+		lastErrors[source] = message
+		if message == "" {
+			logger.Info("recovered", "source", source)
+		} else {
+			logger.Error("error", "source", source, "msg", message)
+		}
+	}
+
+	ticker := time.NewTicker(headlessLogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case inStats = <-inStatusCh:
+			gotInput = true
+			logChangedError("input", inStats.src.errorMsg)
+			logChangedError("input_to_ugps", inStats.dst.errorMsg)
+			logChangedError("retransmit", inStats.retransmit.errorMsg)
+			logChangedError("depth_to_ugps", inStats.depth.errorMsg)
+		case outStats = <-outputStatusChannel:
+			gotOutput = true
+			logChangedError("ugps", outStats.src.errMsg)
+			for i, dst := range outStats.dst {
+				logChangedError("output "+outputDevices[i], dst.errMsg)
+			}
+		case <-ticker.C:
+			if cfg.InputEnabled() {
+				if gotInput {
+					logger.Info("input status",
+						"position", inStats.src.posDesc,
+						"heading", inStats.src.headDesc,
+						"course", inStats.src.courseDesc,
+						"cog", inStats.src.cog,
+						"sog", inStats.src.sog,
+						"parse_errors", inStats.src.unparsableCount,
+						"connection", inStats.conn.String(),
+						"sent_to_ugps", inStats.dst.sendOk,
+						"retransmitted", inStats.retransmit.count)
+				} else {
+					logger.Warn("input status", "msg", "waiting for data")
+				}
+			}
+			if cfg.DepthEnabled() && gotInput {
+				logger.Info("depth status",
+					"depth", inStats.depth.srcDesc,
+					"last_depth", inStats.depth.depth,
+					"sent_to_ugps", inStats.depth.sendOk)
+			}
+			if cfg.OutputEnabled() {
+				if gotOutput {
+					logger.Info("output status",
+						"positions_from_ugps", outStats.src.getCount,
+						"ugps_errors", outStats.src.getErr)
+					for i, dst := range outStats.dst {
+						logger.Info("output destination status",
+							"device", outputDevices[i],
+							"sentences", strings.Join(outputs[i].PositionSentences(), ", "),
+							"sent", dst.sendOk,
+							"send_errors", dst.errCount,
+							"connection", dst.conn.String())
+					}
+				} else {
+					logger.Warn("output status", "msg", "waiting for data")
+				}
+			}
+		case <-ctx.Done():
+			logger.Info("shutting down")
+			return
+		}
+	}
+}
@@ -2,27 +2,32 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adrianmo/go-nmea"
-	"go.bug.st/serial"
+	"github.com/waterlinked/ugps-go/ugps"
 )
 
 type inputStats struct {
 	src struct {
 		posDesc         string
-		posCount        int
+		courseDesc      string
+		cog             float64
+		sog             float64
 		headDesc        string
 		unparsableCount int
 		errorMsg        string
 	}
-	dst struct {
+	conn connectionStatus
+	dst  struct {
 		errorMsg string
 		sendOk   int
 	}
@@ -30,161 +35,449 @@ type inputStats struct {
 		count    int
 		errorMsg string
 	}
+	depth struct {
+		srcDesc  string
+		depth    float64
+		sendOk   int
+		errorMsg string
+	}
 }
 
 const missingDataTimeout = 10
 
-var (
-	stats  inputStats
-	latest externalMaster
-)
+// defaultWaterTemperature in Celsius is sent to the UGPS with the external depth
+const defaultWaterTemperature = 10
+
+// earthRadius in meters used when moving a position by a short distance
+const earthRadius = 6371000
+
+// leverArm is the position of the UGPS topside array relative to the GPS antenna in meters,
+// x forward, y starboard and z down in the vessel frame
+type leverArm struct {
+	X float64 `yaml:"x"`
+	Y float64 `yaml:"y"`
+	// Z is not supported as the UGPS has no antenna height and the vessel pitch and roll is unknown.
+	// It is only in the config to reject a non-zero value.
+	Z float64 `yaml:"z"`
+}
+
+// apply moves the antenna position in master to the array position using the heading in master
+func (l leverArm) apply(master ugps.ExternalMaster) ugps.ExternalMaster {
+	if l.X == 0 && l.Y == 0 {
+		return master
+	}
+	heading := master.Orientation * math.Pi / 180
+	north := l.X*math.Cos(heading) - l.Y*math.Sin(heading)
+	east := l.X*math.Sin(heading) + l.Y*math.Cos(heading)
+	master.Lat += north / earthRadius * 180 / math.Pi
+	master.Lon += east / (earthRadius * math.Cos(master.Lat*math.Pi/180)) * 180 / math.Pi
+	return master
+}
+
+// Input reads position and heading from an external GPS/compass and sends it to the UGPS
+type Input struct {
+	client             *ugps.Client
+	positionParser     nmeaPositionParser
+	courseParser       courseParser
+	headingParser      nmeaHeadingParser
+	retransmit         io.Writer
+	masterCh           chan ugps.ExternalMaster
+	inputStatusChannel chan inputStats
+
+	// declination is updated with the position if not nil
+	declination *declinationSource
+
+	// depthParser is nil if depth input is disabled
+	depthParser nmeaDepthParser
+	depthOffset float64
+	depthCh     chan ugps.ExternalDepth
+
+	// n2k decodes NMEA 2000 input, nil for NMEA 0183
+	n2k *n2kInput
+
+	// leverArm translates the antenna position to the array position
+	leverArm leverArm
+
+	// latest is only accessed by the goroutine reading the input
+	latest ugps.ExternalMaster
+
+	// conn is the input device if it reports the connection status
+	conn connectionStatusReporter
+
+	// mu protects stats which is updated by both the reading and the sending goroutine
+	mu    sync.Mutex
+	stats inputStats
+}
+
+// NewInput creates an Input. retransmit is optional and can be nil.
+func NewInput(client *ugps.Client, positionParser nmeaPositionParser, headingParser nmeaHeadingParser, retransmit io.Writer) *Input {
+	return &Input{
+		client:             client,
+		positionParser:     positionParser,
+		headingParser:      headingParser,
+		retransmit:         retransmit,
+		masterCh:           make(chan ugps.ExternalMaster, 1),
+		inputStatusChannel: make(chan inputStats, 1),
+		depthCh:            make(chan ugps.ExternalDepth, 1),
+	}
+}
+
+// enableDepth sends depth parsed by depthParser to the UGPS. offset in meters is added to the parsed depth.
+func (in *Input) enableDepth(depthParser nmeaDepthParser, offset float64) {
+	in.depthParser = depthParser
+	in.depthOffset = offset
+}
+
+// sendStats sends the stats unless the context is cancelled
+func sendStats[T any](ctx context.Context, ch chan T, s T) {
+	select {
+	case ch <- s:
+	case <-ctx.Done():
+	}
+}
 
-// parseNMEA takes a string and return true if new data, else false
-func parseNMEA(data []byte, headingParse nmeaHeadingParser) (bool, error) {
+// updateStats modifies the stats and sends the result to the status channel
+func (in *Input) updateStats(ctx context.Context, update func(stats *inputStats)) {
+	in.mu.Lock()
+	update(&in.stats)
+	if in.conn != nil {
+		in.stats.conn = in.conn.connectionStatus()
+	}
+	stats := in.stats
+	in.mu.Unlock()
+
+	sendStats(ctx, in.inputStatusChannel, stats)
+}
+
+// parseNMEA takes a string and return true if new position/heading data, else false.
+// New depth data is put in the depth channel.
+func (in *Input) parseNMEA(data []byte) (bool, error) {
 	line := strings.TrimSpace(string(data))
 
 	s, err := nmea.Parse(line)
 	if err != nil {
 		debugPrintf("Parse err: %s (%s)", err, line)
-		stats.src.unparsableCount++
+		in.mu.Lock()
+		in.stats.src.unparsableCount++
+		in.mu.Unlock()
 		return false, nil
 	}
 
-	switch m := s.(type) {
-	case nmea.GGA:
-		debugPrintf("GGA: Lat/lon : %s %s\n", nmea.FormatGPS(m.Latitude), nmea.FormatGPS(m.Longitude))
+	// Course is parsed independently of position as RMC can be used for both
+	course, courseUpdate, err := in.courseParser.parseNMEA(s)
+	if err != nil {
+		return false, err
+	}
+	if courseUpdate {
+		// Invalid course is reset to zero instead of sending a stale course
+		in.latest.Cog = course.Cog
+		in.latest.Sog = course.Sog
+		in.mu.Lock()
+		in.stats.src.courseDesc = in.courseParser.String()
+		in.stats.src.cog = course.Cog
+		in.stats.src.sog = course.Sog
+		in.mu.Unlock()
+	}
 
-		fix, err := strconv.ParseFloat(m.FixQuality, 64)
-		if err != nil {
-			debugPrintf("GGA invalid fix quality: %s -> %v\n", m.FixQuality, err)
-			fix = 0
+	pos, success, err := in.positionParser.parseNMEA(s)
+	if err != nil || success {
+		if success {
+			in.latest.Lat = pos.Lat
+			in.latest.Lon = pos.Lon
+			in.latest.NumSats = pos.NumSats
+			in.latest.FixQuality = pos.FixQuality
+			in.latest.Hdop = pos.Hdop
+			if in.declination != nil && pos.FixQuality != fixQualityInvalid {
+				in.declination.setPosition(pos.Lat, pos.Lon)
+			}
 		}
-		latest.Lat = m.Latitude
-		latest.Lon = m.Longitude
-		latest.NumSats = float64(m.NumSatellites)
-		latest.FixQuality = fix
-		latest.Hdop = m.HDOP
-		//stats.typeGga++
-		stats.src.posCount++
-		stats.src.posDesc = fmt.Sprintf("GGA: %d", stats.src.posCount)
+		in.mu.Lock()
+		in.stats.src.posDesc = in.positionParser.String()
+		in.mu.Unlock()
+		return success, err
+	}
+	if courseUpdate {
 		return true, nil
 	}
-	success, err := headingParse.parseNMEA(s)
-	stats.src.headDesc = headingParse.String()
-	return success, err
-}
-
-func inputUDPLoop(listen string, headingParser nmeaHeadingParser, msg chan externalMaster, inStatsCh chan inputStats, retransmitConn net.Conn) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", listen)
-	if err != nil {
-		log.Fatal(err)
+	if in.depthParser != nil {
+		depth, success, err := in.depthParser.parseNMEA(s)
+		in.mu.Lock()
+		in.stats.depth.srcDesc = in.depthParser.String()
+		in.mu.Unlock()
+		if err != nil || success {
+			if success {
+				extDepth := ugps.ExternalDepth{Depth: depth + in.depthOffset, Temperature: defaultWaterTemperature}
+				select {
+				case in.depthCh <- extDepth: // put message in channel
+				default: // channel is full
+				}
+			}
+			return false, err
+		}
 	}
-
-	ln, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		panic(err)
+	heading, success, err := in.headingParser.parseNMEA(s)
+	if success {
+		in.latest.Orientation = heading
 	}
-	defer ln.Close()
+	in.mu.Lock()
+	in.stats.src.headDesc = in.headingParser.String()
+	in.mu.Unlock()
+	return success, err
+}
 
-	buffer := make([]byte, 1024)
+// contextReader is a reader which stops reading when the context is cancelled.
+// The underlying reader must return regularly (eg. using a read timeout) for
+// the cancellation to be detected.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
 
+func (c contextReader) Read(p []byte) (int, error) {
 	for {
+		if err := c.ctx.Err(); err != nil {
+			return 0, err
+		}
+		n, err := c.r.Read(p)
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
 
-		ln.SetReadDeadline(time.Now().Add(2 * time.Second))
-		n, _, err := ln.ReadFromUDP(buffer)
-
+// handleData retransmits and parses the received data and passes new positions on to the UGPS
+func (in *Input) handleData(ctx context.Context, data []byte) {
+	if in.retransmit != nil {
+		_, err := in.retransmit.Write(data)
+		in.mu.Lock()
 		if err != nil {
-			nerr := err.(net.Error)
-			if nerr != nil && nerr.Timeout() {
-				continue
-			}
-			stats.src.errorMsg = fmt.Sprintf("UDP err: %v\n", err)
-			inStatsCh <- stats
-			continue
+			debugPrintf("Retransmit error: %s", err)
+			in.stats.retransmit.errorMsg = fmt.Sprintf("Retransmit error: %s", err)
+		} else {
+			in.stats.retransmit.count += 1
+			in.stats.retransmit.errorMsg = ""
 		}
+		in.mu.Unlock()
+	}
 
-		data := buffer[:n]
-		if retransmitConn != nil {
-			retransmitConn.SetWriteDeadline(time.Now().Add(1 * time.Second))
-			_, err := retransmitConn.Write(data)
-			if err != nil {
-				debugPrintf("Retransmit error: %s", err)
-				stats.retransmit.errorMsg = fmt.Sprintf("Retransmit error: %s", err)
-			} else {
-				stats.retransmit.count += 1
-				stats.retransmit.errorMsg = ""
-			}
+	var gotUpdate bool
+	var err error
+	if in.n2k != nil {
+		gotUpdate, err = in.parseN2KLines(data)
+	} else {
+		gotUpdate, err = in.parseNMEA(data)
+	}
+	in.publish(ctx, gotUpdate, err)
+}
+
+// publish passes the latest position on to the UGPS if updated and sends the stats
+func (in *Input) publish(ctx context.Context, gotUpdate bool, err error) {
+	if err == nil && gotUpdate {
+		select {
+		case in.masterCh <- in.leverArm.apply(in.latest): // put message in channel
+		default: // channel is full
 		}
+	}
+	in.updateStats(ctx, func(stats *inputStats) {
 		stats.src.errorMsg = ""
-
-		gotUpdate, err := parseNMEA(data, headingParser)
 		if err != nil {
 			stats.src.errorMsg = fmt.Sprintf("%v", err)
-		} else if gotUpdate {
-			select {
-			case msg <- latest: // put message in channel
-			default: // channel is full
-			}
+		}
+	})
+}
+
+// UDPLoop reads NMEA packets from a UDP socket until the context is cancelled.
+// The connection must be closed to stop a blocking read when the context is cancelled.
+func (in *Input) UDPLoop(ctx context.Context, conn *reconnectingConn) {
+	in.connLoop(ctx, conn, in.readPackets)
+}
 
+// StreamLoop reads NMEA lines from a serial port or TCP connection until the context is cancelled.
+// Serial ports must have a read timeout and TCP connections must be closed to stop a blocking read
+// when the context is cancelled.
+func (in *Input) StreamLoop(ctx context.Context, conn *reconnectingConn) {
+	in.connLoop(ctx, conn, in.readLines)
+}
+
+// connLoop reads from the connection until the context is cancelled.
+// The connection is reopened if it fails.
+func (in *Input) connLoop(ctx context.Context, conn *reconnectingConn, read func(context.Context, io.Reader) error) {
+	for ctx.Err() == nil {
+		r, err := conn.get()
+		if err == nil {
+			err = read(ctx, r)
+			if ctx.Err() != nil {
+				return
+			}
+			conn.fail(r, err)
+		}
+		if ctx.Err() != nil {
+			return
 		}
-		inStatsCh <- stats
+		in.updateStats(ctx, func(stats *inputStats) {
+			stats.src.errorMsg = fmt.Sprintf("Error reading %s: %v\n", conn.name, err)
+		})
+		sleepContext(ctx, conn.wait())
 	}
 }
 
-func inputSerialLoop(s serial.Port, headingParser nmeaHeadingParser, msg chan externalMaster, inStatsCh chan inputStats, retransmit io.Writer) {
+// readPackets reads NMEA packets until reading fails or the context is cancelled
+func (in *Input) readPackets(ctx context.Context, r io.Reader) error {
+	buffer := make([]byte, 1024)
+	for {
+		n, err := r.Read(buffer)
+		if err != nil {
+			return err
+		}
+		in.handleData(ctx, buffer[:n])
+	}
+}
 
-	scanner := bufio.NewReader(s)
+// readLines reads NMEA lines until reading fails or the context is cancelled
+func (in *Input) readLines(ctx context.Context, r io.Reader) error {
+	reader := bufio.NewReader(contextReader{ctx: ctx, r: r})
 	for {
-		line, _, err := scanner.ReadLine()
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			return fmt.Errorf("connection closed")
+		}
 		if err != nil {
-			stats.src.errorMsg = fmt.Sprintf("Serial err: %v\n", err)
-			inStatsCh <- stats
-			continue
+			return err
 		}
-		if retransmit != nil {
-			_, err := retransmit.Write(line)
+		in.handleData(ctx, nmeaLine(line))
+	}
+}
+
+// nmeaLine returns a copy of a line read without the line ending, terminated with CR LF as in NMEA 0183.
+// The line ending is needed when the line is retransmitted to a stream.
+func nmeaLine(line []byte) []byte {
+	return append(append(make([]byte, 0, len(line)+2), line...), '\r', '\n')
+}
+
+// TCPListenLoop reads NMEA from all clients connecting to the listener until the context is cancelled
+func (in *Input) TCPListenLoop(ctx context.Context, ln net.Listener) {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	status := &listenerStatus{}
+	in.mu.Lock()
+	in.conn = status
+	in.mu.Unlock()
+
+	// Lines from all clients are handled by this goroutine as the parsers are not safe for concurrent use
+	lines := make(chan []byte)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var delay time.Duration
+		for {
+			conn, err := ln.Accept()
 			if err != nil {
-				debugPrintf("Retransmit error: %s", err)
-				stats.retransmit.errorMsg = fmt.Sprintf("Retransmit error: %s", err)
-			} else {
-				stats.retransmit.count += 1
-				stats.retransmit.errorMsg = ""
+				if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+					return
+				}
+				// Eg. too many open files. Wait before accepting again instead of spinning.
+				delay = nextReconnectDelay(delay)
+				debugPrintf("TCP accept err: %v. Retrying in %v", err, delay)
+				status.acceptFailed(err)
+				in.updateStats(ctx, func(stats *inputStats) {
+					stats.src.errorMsg = fmt.Sprintf("Error accepting clients on %s: %v", ln.Addr(), err)
+				})
+				sleepContext(ctx, delay)
+				continue
 			}
-		}
+			delay = 0
+			debugPrintf("Client connected: %s", conn.RemoteAddr())
+			status.accepted()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				defer status.disconnected()
+				stop := context.AfterFunc(ctx, func() { conn.Close() })
+				defer stop()
 
-		gotUpdate, err := parseNMEA(line, headingParser)
-		stats.src.errorMsg = ""
+				reader := bufio.NewReader(conn)
+				for {
+					line, _, err := reader.ReadLine()
+					if err != nil {
+						debugPrintf("Client disconnected: %s: %v", conn.RemoteAddr(), err)
+						return
+					}
+					select {
+					case lines <- nmeaLine(line):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+	}()
 
-		if err != nil {
-			stats.src.errorMsg = fmt.Sprintf("%v", err)
-		} else if gotUpdate {
-			select {
-			case msg <- latest: // put message in channel
-			default: // channel is full
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+			wg.Wait()
+			return
+		case line := <-lines:
+			in.handleData(ctx, line)
 		}
-		inStatsCh <- stats
 	}
 }
 
-func inputLoop(masterCh chan externalMaster, inputStatusCh chan inputStats) {
+// Loop sends the received positions to the UGPS until the context is cancelled
+func (in *Input) Loop(ctx context.Context) {
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-time.After(missingDataTimeout * time.Second):
-			stats.src.errorMsg = fmt.Sprintf("Got no input after %d seconds, is data being sent?", missingDataTimeout)
-			inputStatusCh <- stats
-		case curr := <-masterCh:
-			err := setExternalMaster(curr)
+			in.updateStats(ctx, func(stats *inputStats) {
+				stats.src.errorMsg = fmt.Sprintf("Got no input after %d seconds, is data being sent?", missingDataTimeout)
+			})
+		case curr := <-in.masterCh:
+			err := in.client.SetExternalMaster(ctx, curr)
 			if err == nil {
-				stats.dst.sendOk++
-				stats.dst.errorMsg = ""
-			} else {
+				in.mu.Lock()
+				in.stats.dst.sendOk++
+				in.stats.dst.errorMsg = ""
+				in.mu.Unlock()
+			} else if ctx.Err() == nil {
 				debugPrintf("%v", err)
-				stats.dst.errorMsg = fmt.Sprintf("%v", err)
-				inputStatusCh <- stats
+				in.updateStats(ctx, func(stats *inputStats) {
+					stats.dst.errorMsg = fmt.Sprintf("%v", err)
+				})
+			}
+			// Sleep to make maximum "setExternalMaster" frequency of 20 Hz
+			select {
+			case <-ctx.Done():
+			case <-time.After(50 * time.Millisecond):
+			}
+		case depth := <-in.depthCh:
+			err := in.client.SetExternalDepth(ctx, depth)
+			if ctx.Err() != nil {
+				continue
+			}
+			if err != nil {
+				debugPrintf("%v", err)
+			}
+			in.updateStats(ctx, func(stats *inputStats) {
+				stats.depth.depth = depth.Depth
+				stats.depth.errorMsg = ""
+				if err == nil {
+					stats.depth.sendOk++
+				} else {
+					stats.depth.errorMsg = fmt.Sprintf("%v", err)
+				}
+			})
+			// Sleep to make maximum "setExternalDepth" frequency of 20 Hz
+			select {
+			case <-ctx.Done():
+			case <-time.After(50 * time.Millisecond):
 			}
-			time.Sleep(50 * time.Millisecond) // Sleep to make maximum "setExternalMaster" frequency of 20 Hz
 		}
 	}
 }
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// NMEA 2000 PGNs received from the vessel in addition to the position PGNs
+const (
+	pgnVesselHeading     = 127250
+	pgnCOGSOGRapidUpdate = 129026
+)
+
+// n2kFastPacketPGNs are the received PGNs sent as fast packets, the others are single frame
+var n2kFastPacketPGNs = map[uint32]bool{
+	pgnGNSSPositionData: true,
+}
+
+// Flags and mask of the identifier in struct can_frame
+const (
+	canRTRFlag = 0x40000000
+	canERRFlag = 0x20000000
+	canEFFMask = 0x1FFFFFFF
+)
+
+// parseCanID returns a message without data with the PGN, priority, source and destination
+// of a 29 bit CAN identifier
+func parseCanID(id uint32) n2kMessage {
+	msg := n2kMessage{priority: uint8(id>>26) & 0x7, source: uint8(id), destination: n2kBroadcast}
+	pdu := (id >> 16) & 0xFF
+	if pdu < 240 {
+		// PDU1 format, the PS field is the destination and not part of the PGN
+		msg.pgn = (id >> 8) & 0x3FF00
+		msg.destination = uint8(id >> 8)
+	} else {
+		msg.pgn = (id >> 8) & 0x3FFFF
+	}
+	return msg
+}
+
+// decodeCANFrame decodes a SocketCAN frame (struct can_frame)
+func decodeCANFrame(frame []byte) (n2kMessage, error) {
+	if len(frame) != canFrameSize {
+		return n2kMessage{}, fmt.Errorf("CAN frame should be %d bytes, got %d", canFrameSize, len(frame))
+	}
+	id := binary.NativeEndian.Uint32(frame)
+	if id&canEFFFlag == 0 || id&(canRTRFlag|canERRFlag) != 0 {
+		return n2kMessage{}, fmt.Errorf("not a NMEA 2000 data frame: %08X", id)
+	}
+	length := int(frame[4])
+	if length > n2kMaxSingleFrame {
+		return n2kMessage{}, fmt.Errorf("invalid CAN frame length: %d", length)
+	}
+	msg := parseCanID(id & canEFFMask)
+	msg.data = append([]byte(nil), frame[8:8+length]...)
+	return msg, nil
+}
+
+// decodeYDRawLine decodes a CAN frame in the Yacht Devices RAW format. Lines from the gateway
+// start with the time and direction: 17:33:21.107 R 19F51323 01 02 03
+func decodeYDRawLine(line string) (n2kMessage, error) {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && (fields[1] == "R" || fields[1] == "T") {
+		fields = fields[2:]
+	}
+	if len(fields) == 0 || len(fields) > n2kMaxSingleFrame+1 {
+		return n2kMessage{}, fmt.Errorf("invalid YDRAW line: %s", line)
+	}
+	id, err := strconv.ParseUint(fields[0], 16, 29)
+	if err != nil {
+		return n2kMessage{}, fmt.Errorf("invalid CAN identifier in YDRAW line: %s", line)
+	}
+	msg := parseCanID(uint32(id))
+	for _, field := range fields[1:] {
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return n2kMessage{}, fmt.Errorf("invalid data in YDRAW line: %s", line)
+		}
+		msg.data = append(msg.data, uint8(b))
+	}
+	return msg, nil
+}
+
+// decodeActisenseASCII decodes a message in the Actisense ASCII format: A173321.107 23FF7 1F513 012F3070002F30709F
+// with the time, the source, destination and priority, the PGN and the data. Fast packets are already joined.
+func decodeActisenseASCII(line string) (n2kMessage, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || len(fields) > 4 || !strings.HasPrefix(fields[0], "A") || len(fields[1]) != 5 {
+		return n2kMessage{}, fmt.Errorf("invalid Actisense ASCII line: %s", line)
+	}
+	header, err := strconv.ParseUint(fields[1], 16, 20)
+	if err != nil {
+		return n2kMessage{}, fmt.Errorf("invalid address in Actisense ASCII line: %s", line)
+	}
+	pgn, err := strconv.ParseUint(fields[2], 16, 18)
+	if err != nil {
+		return n2kMessage{}, fmt.Errorf("invalid PGN in Actisense ASCII line: %s", line)
+	}
+	msg := n2kMessage{pgn: uint32(pgn), priority: uint8(header & 0xF), source: uint8(header >> 12), destination: uint8(header >> 4)}
+	if len(fields) == 4 {
+		msg.data, err = hex.DecodeString(fields[3])
+		if err != nil {
+			return n2kMessage{}, fmt.Errorf("invalid data in Actisense ASCII line: %s", line)
+		}
+	}
+	return msg, nil
+}
+
+// n2kInputFormat decodes lines from a NMEA 2000 gateway
+type n2kInputFormat struct {
+	decode func(line string) (n2kMessage, error)
+	// frames is true if each line is a CAN frame and fast packets must be joined
+	frames bool
+}
+
+// n2kInputFormats are the supported NMEA 2000 gateway formats for input
+var n2kInputFormats = map[string]n2kInputFormat{
+	"ydraw":           {decode: decodeYDRawLine, frames: true},
+	"actisense-ascii": {decode: decodeActisenseASCII},
+}
+
+type n2kFastPacketKey struct {
+	pgn    uint32
+	source uint8
+}
+
+type n2kFastPacket struct {
+	sequence uint8
+	length   int
+	next     uint8
+	data     []byte
+}
+
+// n2kFastPackets joins the frames of fast packet messages
+type n2kFastPackets struct {
+	partial map[n2kFastPacketKey]*n2kFastPacket
+}
+
+// add returns the message when the frame completes it. Frames of single frame PGNs are returned as they are.
+func (f *n2kFastPackets) add(frame n2kMessage) (n2kMessage, bool) {
+	if !n2kFastPacketPGNs[frame.pgn] {
+		return frame, true
+	}
+	if len(frame.data) < 2 {
+		return n2kMessage{}, false
+	}
+	if f.partial == nil {
+		f.partial = make(map[n2kFastPacketKey]*n2kFastPacket)
+	}
+	key := n2kFastPacketKey{pgn: frame.pgn, source: frame.source}
+	sequence, index := frame.data[0]>>5, frame.data[0]&0x1F
+	packet := f.partial[key]
+	if index == 0 {
+		packet = &n2kFastPacket{sequence: sequence, length: int(frame.data[1]), next: 1, data: append([]byte(nil), frame.data[2:]...)}
+		f.partial[key] = packet
+	} else {
+		if packet == nil || packet.sequence != sequence || packet.next != index {
+			// A frame was lost, wait for the next message
+			delete(f.partial, key)
+			return n2kMessage{}, false
+		}
+		packet.data = append(packet.data, frame.data[1:]...)
+		packet.next++
+	}
+	if len(packet.data) < packet.length {
+		return n2kMessage{}, false
+	}
+	delete(f.partial, key)
+	frame.data = packet.data[:packet.length]
+	return frame, true
+}
+
+// n2kInput decodes position, heading and course from NMEA 2000 messages
+type n2kInput struct {
+	format      n2kInputFormat
+	fastPackets n2kFastPackets
+	// headingOffset in degrees is added to the heading
+	headingOffset float64
+	// hasGNSSData is true when PGN 129029 has been received with the fix quality
+	hasGNSSData bool
+	counts      map[uint32]int
+}
+
+func newN2KInput(format n2kInputFormat, headingOffset float64) *n2kInput {
+	return &n2kInput{format: format, headingOffset: headingOffset, counts: make(map[uint32]int)}
+}
+
+// describe returns the number of messages received of each PGN
+func (p *n2kInput) describe(pgns ...uint32) string {
+	descs := make([]string, 0, len(pgns))
+	for _, pgn := range pgns {
+		descs = append(descs, fmt.Sprintf("%d: %d", pgn, p.counts[pgn]))
+	}
+	return strings.Join(descs, " ")
+}
+
+// radiansToDegrees converts a NMEA 2000 angle in 1e-4 radians to degrees
+func radiansToDegrees(v uint16) float64 {
+	return float64(v) * 1e-4 * 180 / math.Pi
+}
+
+// decodePositionRapidUpdate decodes the position in PGN 129025, false if the position is not available
+func decodePositionRapidUpdate(data []byte) (float64, float64, bool, error) {
+	if len(data) < 8 {
+		return 0, 0, false, fmt.Errorf("PGN %d too short: %d bytes", pgnPositionRapidUpdate, len(data))
+	}
+	lat := int32(binary.LittleEndian.Uint32(data[0:]))
+	lon := int32(binary.LittleEndian.Uint32(data[4:]))
+	if lat == n2kInt32NA || lon == n2kInt32NA {
+		return 0, 0, false, nil
+	}
+	return float64(lat) * 1e-7, float64(lon) * 1e-7, true, nil
+}
+
+// decodeGNSSPositionData decodes PGN 129029. The GNSS method is the same as the GGA fix quality.
+// A position which is not available has an invalid fix quality.
+func decodeGNSSPositionData(data []byte) (topsidePosition, bool, error) {
+	if len(data) < 43 {
+		return topsidePosition{}, false, fmt.Errorf("PGN %d too short: %d bytes", pgnGNSSPositionData, len(data))
+	}
+	lat := int64(binary.LittleEndian.Uint64(data[7:]))
+	lon := int64(binary.LittleEndian.Uint64(data[15:]))
+	if lat == n2kInt64NA || lon == n2kInt64NA {
+		return topsidePosition{FixQuality: fixQualityInvalid}, false, nil
+	}
+	pos := topsidePosition{Lat: float64(lat) * 1e-16, Lon: float64(lon) * 1e-16}
+	if method := data[31] >> 4; method <= fixQualitySimulation {
+		pos.FixQuality = float64(method)
+	}
+	if numSats := data[33]; numSats != n2kUint8NA {
+		pos.NumSats = float64(numSats)
+	}
+	if hdop := int16(binary.LittleEndian.Uint16(data[34:])); hdop != n2kInt16NA {
+		pos.Hdop = float64(hdop) / 100
+	}
+	return pos, true, nil
+}
+
+// Heading and course reference in PGN 127250 and 129026
+const (
+	n2kReferenceTrue     = 0
+	n2kReferenceMagnetic = 1
+)
+
+// decodeVesselHeading decodes the true heading in PGN 127250. Magnetic heading is converted using
+// the deviation and variation in the message, the declination is used if it has no variation.
+func decodeVesselHeading(data []byte, declination *declinationSource) (float64, bool, error) {
+	if len(data) < 8 {
+		return 0, false, fmt.Errorf("PGN %d too short: %d bytes", pgnVesselHeading, len(data))
+	}
+	raw := binary.LittleEndian.Uint16(data[1:])
+	if raw == n2kUint16NA {
+		return 0, false, nil
+	}
+	heading := radiansToDegrees(raw)
+	switch data[7] & 0x3 {
+	case n2kReferenceTrue:
+	case n2kReferenceMagnetic:
+		if deviation := int16(binary.LittleEndian.Uint16(data[3:])); deviation != n2kInt16NA {
+			heading += float64(deviation) * 1e-4 * 180 / math.Pi
+		}
+		if variation := int16(binary.LittleEndian.Uint16(data[5:])); variation != n2kInt16NA {
+			heading += float64(variation) * 1e-4 * 180 / math.Pi
+		} else {
+			heading += declination.declination()
+		}
+	default:
+		return 0, false, nil
+	}
+	return normaliseHeading(heading), true, nil
+}
+
+// decodeCOGSOG decodes PGN 129026 with speed in knots. Magnetic course is converted using the declination.
+// Course or speed not available is returned as invalid.
+func decodeCOGSOG(data []byte, declination *declinationSource) (topsideCourse, error) {
+	if len(data) < 6 {
+		return topsideCourse{}, fmt.Errorf("PGN %d too short: %d bytes", pgnCOGSOGRapidUpdate, len(data))
+	}
+	cog := binary.LittleEndian.Uint16(data[2:])
+	sog := binary.LittleEndian.Uint16(data[4:])
+	reference := data[1] & 0x3
+	if cog == n2kUint16NA || sog == n2kUint16NA || reference > n2kReferenceMagnetic {
+		return topsideCourse{}, nil
+	}
+	course := radiansToDegrees(cog)
+	if reference == n2kReferenceMagnetic {
+		course += declination.declination()
+	}
+	return topsideCourse{Cog: normaliseHeading(course), Sog: float64(sog) * 0.01 * 3600 / metersPerNauticalMile}, nil
+}
+
+// parseN2K updates the latest position, heading and course from a NMEA 2000 message.
+// Returns true if new position/heading data, else false.
+func (in *Input) parseN2K(msg n2kMessage) (bool, error) {
+	p := in.n2k
+	switch msg.pgn {
+	case pgnPositionRapidUpdate:
+		lat, lon, ok, err := decodePositionRapidUpdate(msg.data)
+		if err != nil || !ok {
+			return false, err
+		}
+		debugPrintf("%d: Lat/lon : %f %f\n", msg.pgn, lat, lon)
+		in.latest.Lat = lat
+		in.latest.Lon = lon
+		if !p.hasGNSSData {
+			// The fix quality is only known from PGN 129029
+			in.latest.FixQuality = fixQualityGPS
+		}
+		if in.declination != nil {
+			in.declination.setPosition(lat, lon)
+		}
+	case pgnGNSSPositionData:
+		pos, ok, err := decodeGNSSPositionData(msg.data)
+		if err != nil {
+			return false, err
+		}
+		debugPrintf("%d: Lat/lon : %f %f %v\n", msg.pgn, pos.Lat, pos.Lon, pos.FixQuality)
+		p.hasGNSSData = true
+		if ok {
+			in.latest.Lat = pos.Lat
+			in.latest.Lon = pos.Lon
+			if in.declination != nil && pos.FixQuality != fixQualityInvalid {
+				in.declination.setPosition(pos.Lat, pos.Lon)
+			}
+		}
+		in.latest.NumSats = pos.NumSats
+		in.latest.FixQuality = pos.FixQuality
+		in.latest.Hdop = pos.Hdop
+	case pgnVesselHeading:
+		heading, ok, err := decodeVesselHeading(msg.data, in.declination)
+		if err != nil || !ok {
+			return false, err
+		}
+		debugPrintf("%d: Heading : %f\n", msg.pgn, heading)
+		in.latest.Orientation = normaliseHeading(heading + p.headingOffset)
+	case pgnCOGSOGRapidUpdate:
+		course, err := decodeCOGSOG(msg.data, in.declination)
+		if err != nil {
+			return false, err
+		}
+		debugPrintf("%d: Course : %f Speed: %f kn\n", msg.pgn, course.Cog, course.Sog)
+		in.latest.Cog = course.Cog
+		in.latest.Sog = course.Sog
+		in.mu.Lock()
+		in.stats.src.cog = course.Cog
+		in.stats.src.sog = course.Sog
+		in.mu.Unlock()
+	default:
+		return false, nil
+	}
+	p.counts[msg.pgn]++
+	in.mu.Lock()
+	in.stats.src.posDesc = p.describe(pgnPositionRapidUpdate, pgnGNSSPositionData)
+	in.stats.src.headDesc = p.describe(pgnVesselHeading)
+	in.stats.src.courseDesc = p.describe(pgnCOGSOGRapidUpdate)
+	in.mu.Unlock()
+	return true, nil
+}
+
+// parseN2KLines decodes the lines from a NMEA 2000 gateway. Returns true if new position/heading data, else false.
+func (in *Input) parseN2KLines(data []byte) (bool, error) {
+	gotUpdate := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		msg, err := in.n2k.format.decode(line)
+		if err != nil {
+			debugPrintf("Parse err: %s", err)
+			in.mu.Lock()
+			in.stats.src.unparsableCount++
+			in.mu.Unlock()
+			continue
+		}
+		if in.n2k.format.frames {
+			var complete bool
+			if msg, complete = in.n2k.fastPackets.add(msg); !complete {
+				continue
+			}
+		}
+		update, err := in.parseN2K(msg)
+		if err != nil {
+			return gotUpdate, err
+		}
+		gotUpdate = gotUpdate || update
+	}
+	return gotUpdate, nil
+}
+
+// handleCANFrame parses a SocketCAN frame and passes new positions on to the UGPS
+func (in *Input) handleCANFrame(ctx context.Context, frame []byte) {
+	gotUpdate := false
+	msg, err := decodeCANFrame(frame)
+	if err != nil {
+		debugPrintf("Parse err: %s", err)
+		in.mu.Lock()
+		in.stats.src.unparsableCount++
+		in.mu.Unlock()
+		err = nil
+	} else if msg, complete := in.n2k.fastPackets.add(msg); complete {
+		gotUpdate, err = in.parseN2K(msg)
+	}
+	in.publish(ctx, gotUpdate, err)
+}
+
+// CANLoop reads NMEA 2000 frames from a SocketCAN interface until the context is cancelled.
+// The connection must be closed to stop a blocking read when the context is cancelled.
+func (in *Input) CANLoop(ctx context.Context, conn *reconnectingConn) {
+	in.connLoop(ctx, conn, in.readCANFrames)
+}
+
+// readCANFrames reads CAN frames until reading fails or the context is cancelled
+func (in *Input) readCANFrames(ctx context.Context, r io.Reader) error {
+	frame := make([]byte, canFrameSize)
+	for {
+		n, err := r.Read(frame)
+		if err != nil {
+			return err
+		}
+		in.handleCANFrame(ctx, frame[:n])
+	}
+}
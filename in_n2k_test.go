@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCanID(t *testing.T) {
+	for _, msg := range []n2kMessage{
+		{pgn: pgnPositionRapidUpdate, priority: 2, source: 5, destination: n2kBroadcast},
+		{pgn: 59904, priority: 6, source: 100, destination: 35},
+	} {
+		assert.Equal(t, msg, parseCanID(msg.canID()))
+	}
+}
+
+func TestDecodeN2KLines(t *testing.T) {
+	msg, err := decodeYDRawLine("17:33:21.107 R 09F11205 00 5C 3D FF 7F 5D 01 FD")
+	require.NoError(t, err)
+	assert.Equal(t, n2kMessage{pgn: pgnVesselHeading, priority: 2, source: 5, destination: n2kBroadcast,
+		data: []byte{0x00, 0x5C, 0x3D, 0xFF, 0x7F, 0x5D, 0x01, 0xFD}}, msg)
+
+	// Lines as written by the YDRAW output
+	msg, err = decodeYDRawLine("0DF80164 01 02")
+	require.NoError(t, err)
+	assert.Equal(t, n2kMessage{pgn: pgnPositionRapidUpdate, priority: 3, source: 100, destination: n2kBroadcast, data: []byte{1, 2}}, msg)
+
+	msg, err = decodeActisenseASCII("A173321.107 05FF2 1F112 005C3DFF7F5D01FD")
+	require.NoError(t, err)
+	assert.Equal(t, n2kMessage{pgn: pgnVesselHeading, priority: 2, source: 5, destination: n2kBroadcast,
+		data: []byte{0x00, 0x5C, 0x3D, 0xFF, 0x7F, 0x5D, 0x01, 0xFD}}, msg)
+
+	for _, invalid := range []string{"", "17:33:21.107 R", "XYZ 01", "09F11205 01 02 03 04 05 06 07 08 09", "09F11205 100"} {
+		_, err := decodeYDRawLine(invalid)
+		assert.Error(t, err, invalid)
+	}
+	for _, invalid := range []string{"", "B173321.107 05FF2 1F112 00", "A173321.107 05FF 1F112 00", "A173321.107 05FF2 1F112 0"} {
+		_, err := decodeActisenseASCII(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDecodeCANFrame(t *testing.T) {
+	msg := n2kMessage{pgn: pgnPositionRapidUpdate, priority: 2, source: 5, destination: n2kBroadcast, data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	decoded, err := decodeCANFrame(encodeSocketCAN(msg, 0)[0])
+	require.NoError(t, err)
+	assert.Equal(t, msg, decoded)
+
+	// Standard 11 bit frames are not NMEA 2000
+	frame := make([]byte, canFrameSize)
+	binary.NativeEndian.PutUint32(frame, 0x123)
+	_, err = decodeCANFrame(frame)
+	assert.Error(t, err)
+}
+
+func TestN2KFastPacketsJoin(t *testing.T) {
+	msg := gnssPositionData(7, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), 63.4, -10.4, 0, fixQualityRTK, 12, 0.7)
+	msg.source = 5
+	frames := msg.frames(3)
+	require.Len(t, frames, 7)
+
+	var packets n2kFastPackets
+	for i, frame := range frames {
+		joined, complete := packets.add(n2kMessage{pgn: msg.pgn, priority: msg.priority, source: msg.source, destination: msg.destination, data: frame})
+		if i < len(frames)-1 {
+			assert.False(t, complete)
+			continue
+		}
+		require.True(t, complete)
+		assert.Equal(t, msg, joined)
+	}
+
+	// A lost frame drops the message
+	for i, frame := range frames {
+		if i == 2 {
+			continue
+		}
+		_, complete := packets.add(n2kMessage{pgn: msg.pgn, source: msg.source, data: frame})
+		assert.False(t, complete)
+	}
+
+	// Single frame PGNs are not joined
+	single := n2kMessage{pgn: pgnPositionRapidUpdate, data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	joined, complete := packets.add(single)
+	assert.True(t, complete)
+	assert.Equal(t, single, joined)
+}
+
+func TestDecodeN2KPGNs(t *testing.T) {
+	lat, lon, ok, err := decodePositionRapidUpdate(n2kRapidPosition{}.serialise(testFix(), 0).data)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 63.4, lat, 1e-7)
+	assert.InDelta(t, -10.4, lon, 1e-7)
+	_, _, ok, err = decodePositionRapidUpdate(n2kRapidPosition{}.noPosition(0).data)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, _, _, err = decodePositionRapidUpdate([]byte{1, 2})
+	assert.Error(t, err)
+
+	pos, ok, err := decodeGNSSPositionData(gnssPositionData(0, time.Now(), 63.4, -10.4, 0, fixQualityRTK, 12, 0.7).data)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 63.4, pos.Lat, 1e-9)
+	assert.InDelta(t, -10.4, pos.Lon, 1e-9)
+	assert.Equal(t, topsidePosition{Lat: pos.Lat, Lon: pos.Lon, FixQuality: fixQualityRTK, NumSats: 12, Hdop: 0.7}, pos)
+	pos, ok, err = decodeGNSSPositionData(gnssPositionData(0, time.Now(), math.NaN(), math.NaN(), math.NaN(), QualityNoFix, 0, 0).data)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, topsidePosition{}, pos)
+
+	heading, ok, err := decodeVesselHeading([]byte{0x00, 0x5C, 0x3D, 0xFF, 0x7F, 0xFF, 0x7F, 0xFC}, nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 90, heading, 0.01)
+
+	// Magnetic heading with variation 2 degrees east
+	heading, ok, err = decodeVesselHeading([]byte{0x00, 0x5C, 0x3D, 0xFF, 0x7F, 0x5D, 0x01, 0xFD}, nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 92, heading, 0.01)
+
+	// Magnetic heading without variation uses the declination
+	heading, ok, err = decodeVesselHeading([]byte{0x00, 0x5C, 0x3D, 0xFF, 0x7F, 0xFF, 0x7F, 0xFD}, newDeclinationSource(-95, false))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 355, heading, 0.01)
+
+	_, ok, err = decodeVesselHeading([]byte{0x00, 0xFF, 0xFF, 0xFF, 0x7F, 0xFF, 0x7F, 0xFC}, nil)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	course, err := decodeCOGSOG([]byte{0x00, 0xFC, 0x5C, 0x3D, 0x03, 0x02, 0xFF, 0xFF}, nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 90, course.Cog, 0.01)
+	assert.InDelta(t, 10.01, course.Sog, 0.01)
+
+	course, err = decodeCOGSOG([]byte{0x00, 0xFC, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, topsideCourse{}, course)
+}
+
+func TestInputN2K(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	in.n2k = newN2KInput(n2kInputFormats["ydraw"], 5)
+
+	// Heading and position as received from a YDWG gateway in one UDP packet
+	gnss := gnssPositionData(1, time.Now(), 63.4, -10.4, 0, fixQualityDGPS, 9, 0.8)
+	gnss.source = 5
+	data := []byte("17:33:21.107 R 09F11205 00 5C 3D FF 7F FF 7F FC\r\n")
+	data = append(data, encodeYDRaw(gnss, 0)[0]...)
+	in.handleData(ctx, data)
+
+	stats := <-in.inputStatusChannel
+	assert.Equal(t, "129025: 0 129029: 1", stats.src.posDesc)
+	assert.Equal(t, "127250: 1", stats.src.headDesc)
+	assert.Equal(t, 0, stats.src.unparsableCount)
+	ext := <-in.masterCh
+	assert.InDelta(t, 63.4, ext.Lat, 1e-9)
+	assert.InDelta(t, -10.4, ext.Lon, 1e-9)
+	assert.Equal(t, float64(fixQualityDGPS), ext.FixQuality)
+	assert.Equal(t, 9.0, ext.NumSats)
+	assert.InDelta(t, 95, ext.Orientation, 0.01)
+
+	// Rapid position updates keep the fix quality from PGN 129029
+	in.handleData(ctx, encodeYDRaw(n2kRapidPosition{}.serialise(testFix(), 0), 0)[0])
+	stats = <-in.inputStatusChannel
+	assert.Equal(t, "129025: 1 129029: 1", stats.src.posDesc)
+	ext = <-in.masterCh
+	assert.Equal(t, float64(fixQualityDGPS), ext.FixQuality)
+
+	in.handleData(ctx, []byte("not a frame"))
+	stats = <-in.inputStatusChannel
+	assert.Equal(t, 1, stats.src.unparsableCount)
+}
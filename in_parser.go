@@ -2,20 +2,291 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/adrianmo/go-nmea"
+	"github.com/waterlinked/ugps-go/wmm"
 )
 
+// topsidePosition is the position of the topside GPS
+type topsidePosition struct {
+	Lat        float64
+	Lon        float64
+	FixQuality float64
+	NumSats    float64
+	Hdop       float64
+}
+
+// nmeaPositionParser is the interface parsing position input
+type nmeaPositionParser interface {
+	// parseNMEA takes a nmea.Sentence and returns the position and true if new data, else false
+	parseNMEA(sentence nmea.Sentence) (topsidePosition, bool, error)
+	// String returns a string representing the current status
+	String() string
+}
+
+// Fix qualities as used in GGA, used to report the fix quality from other sentences
+const (
+	fixQualityInvalid    = 0
+	fixQualityGPS        = 1
+	fixQualityDGPS       = 2
+	fixQualityPPS        = 3
+	fixQualityRTK        = 4
+	fixQualityFloatRTK   = 5
+	fixQualityEstimated  = 6
+	fixQualityManual     = 7
+	fixQualitySimulation = 8
+)
+
+type ggaParser struct {
+	count int
+}
+type gnsParser struct {
+	count int
+}
+type rmcParser struct {
+	count int
+}
+type gllParser struct {
+	count int
+}
+
+func (p *ggaParser) parseNMEA(sentence nmea.Sentence) (topsidePosition, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.GGA:
+		debugPrintf("GGA: Lat/lon : %s %s\n", nmea.FormatGPS(m.Latitude), nmea.FormatGPS(m.Longitude))
+
+		fix, err := strconv.ParseFloat(m.FixQuality, 64)
+		if err != nil {
+			debugPrintf("GGA invalid fix quality: %s -> %v\n", m.FixQuality, err)
+			fix = fixQualityInvalid
+		}
+		p.count++
+		return topsidePosition{
+			Lat:        m.Latitude,
+			Lon:        m.Longitude,
+			NumSats:    float64(m.NumSatellites),
+			FixQuality: fix,
+			Hdop:       m.HDOP,
+		}, true, nil
+	}
+	return topsidePosition{}, false, nil
+}
+
+func (p ggaParser) String() string {
+	return fmt.Sprintf("GGA: %d", p.count)
+}
+
+// gnsModeFixQuality maps GNS mode to GGA fix quality, in order of preference
+var gnsModeFixQuality = []struct {
+	mode    string
+	quality float64
+}{
+	{nmea.RealTimeKinematicGNS, fixQualityRTK},
+	{nmea.FloatRTKGNS, fixQualityFloatRTK},
+	{nmea.PreciseGNS, fixQualityPPS},
+	{nmea.DifferentialGNS, fixQualityDGPS},
+	{nmea.AutonomousGNS, fixQualityGPS},
+	{nmea.EstimatedGNS, fixQualityEstimated},
+	{nmea.ManualGNS, fixQualityManual},
+	{nmea.SimulatorGNS, fixQualitySimulation},
+}
+
+func (p *gnsParser) parseNMEA(sentence nmea.Sentence) (topsidePosition, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.GNS:
+		debugPrintf("GNS: Lat/lon : %s %s %v\n", nmea.FormatGPS(m.Latitude), nmea.FormatGPS(m.Longitude), m.Mode)
+
+		// GNS has one mode per constellation, use the best
+		fix := float64(fixQualityInvalid)
+	modes:
+		for _, candidate := range gnsModeFixQuality {
+			for _, mode := range m.Mode {
+				if mode == candidate.mode {
+					fix = candidate.quality
+					break modes
+				}
+			}
+		}
+		p.count++
+		return topsidePosition{
+			Lat:        m.Latitude,
+			Lon:        m.Longitude,
+			NumSats:    float64(m.SVs),
+			FixQuality: fix,
+			Hdop:       m.HDOP,
+		}, true, nil
+	}
+	return topsidePosition{}, false, nil
+}
+
+func (p gnsParser) String() string {
+	return fmt.Sprintf("GNS: %d", p.count)
+}
+
+func (p *rmcParser) parseNMEA(sentence nmea.Sentence) (topsidePosition, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.RMC:
+		debugPrintf("RMC: Lat/lon : %s %s (%s)\n", nmea.FormatGPS(m.Latitude), nmea.FormatGPS(m.Longitude), m.Validity)
+
+		fix := float64(fixQualityGPS)
+		if m.Validity != nmea.ValidRMC {
+			fix = fixQualityInvalid
+		}
+		p.count++
+		// RMC has no satellite count or HDOP. Course and speed is handled by courseParser
+		return topsidePosition{
+			Lat:        m.Latitude,
+			Lon:        m.Longitude,
+			FixQuality: fix,
+		}, true, nil
+	}
+	return topsidePosition{}, false, nil
+}
+
+func (p rmcParser) String() string {
+	return fmt.Sprintf("RMC: %d", p.count)
+}
+
+func (p *gllParser) parseNMEA(sentence nmea.Sentence) (topsidePosition, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.GLL:
+		debugPrintf("GLL: Lat/lon : %s %s (%s)\n", nmea.FormatGPS(m.Latitude), nmea.FormatGPS(m.Longitude), m.Validity)
+
+		fix := float64(fixQualityGPS)
+		if m.Validity != nmea.ValidGLL {
+			fix = fixQualityInvalid
+		}
+		p.count++
+		// GLL has no satellite count or HDOP
+		return topsidePosition{
+			Lat:        m.Latitude,
+			Lon:        m.Longitude,
+			FixQuality: fix,
+		}, true, nil
+	}
+	return topsidePosition{}, false, nil
+}
+
+func (p gllParser) String() string {
+	return fmt.Sprintf("GLL: %d", p.count)
+}
+
+// topsideCourse is the course and speed over ground of the topside.
+// It is zero if the data is flagged as invalid, so a stale course is not sent.
+type topsideCourse struct {
+	// Cog is course over ground in degrees true
+	Cog float64
+	// Sog is speed over ground in knots
+	Sog float64
+}
+
+const kphPerKnot = 1.852
+
+// courseParser parses course and speed over ground from VTG and RMC
+type courseParser struct {
+	vtgCount int
+	rmcCount int
+}
+
+// parseNMEA takes a nmea.Sentence and returns the course and true if new data, else false
+func (p *courseParser) parseNMEA(sentence nmea.Sentence) (topsideCourse, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.VTG:
+		debugPrintf("VTG: Course : %f Speed: %f kn %f km/h (%s)\n", m.TrueTrack, m.GroundSpeedKnots, m.GroundSpeedKPH, m.FFAMode)
+		p.vtgCount++
+		// FAA mode N means data not valid (NMEA 2.3 and later)
+		if m.FFAMode == nmea.FAAModeDataNotValid {
+			return topsideCourse{}, true, nil
+		}
+		speed := m.GroundSpeedKnots
+		if speed == 0 && m.GroundSpeedKPH != 0 {
+			// Not all devices fill in knots
+			speed = m.GroundSpeedKPH / kphPerKnot
+		}
+		return topsideCourse{Cog: m.TrueTrack, Sog: speed}, true, nil
+	case nmea.RMC:
+		debugPrintf("RMC: Course : %f Speed: %f kn (%s)\n", m.Course, m.Speed, m.Validity)
+		p.rmcCount++
+		if m.Validity != nmea.ValidRMC || m.FFAMode == nmea.FAAModeDataNotValid {
+			return topsideCourse{}, true, nil
+		}
+		return topsideCourse{Cog: m.Course, Sog: m.Speed}, true, nil
+	}
+	return topsideCourse{}, false, nil
+}
+
+func (p courseParser) String() string {
+	return fmt.Sprintf("VTG: %d RMC: %d", p.vtgCount, p.rmcCount)
+}
+
 // nmeaHeadingParser is the interface parsing heading input
 type nmeaHeadingParser interface {
-	// parseNMEA takes a nmea.Sentence and return true if new data, else false
-	parseNMEA(sentence nmea.Sentence) (bool, error)
+	// parseNMEA takes a nmea.Sentence and returns the heading and true if new data, else false
+	parseNMEA(sentence nmea.Sentence) (float64, bool, error)
 	// String returns a string representing the current status
 	String() string
 }
 
+// declinationSource provides the magnetic declination used to convert magnetic heading to true heading
+type declinationSource struct {
+	// fixed declination in degrees, east positive
+	fixed float64
+	// useWMM calculates the declination from the position using the World Magnetic Model
+	useWMM      bool
+	lat         float64
+	lon         float64
+	hasPosition bool
+	now         func() time.Time
+}
+
+func newDeclinationSource(fixed float64, useWMM bool) *declinationSource {
+	return &declinationSource{fixed: fixed, useWMM: useWMM, now: time.Now}
+}
+
+// setPosition sets the position used by the World Magnetic Model
+func (d *declinationSource) setPosition(lat float64, lon float64) {
+	d.lat = lat
+	d.lon = lon
+	d.hasPosition = true
+}
+
+// declination returns the declination in degrees, east positive.
+// The fixed declination is used until a position is known when using the World Magnetic Model.
+func (d *declinationSource) declination() float64 {
+	if d == nil {
+		return 0
+	}
+	if d.useWMM && d.hasPosition {
+		return wmm.Declination(d.lat, d.lon, 0, d.now())
+	}
+	return d.fixed
+}
+
+// normaliseHeading returns the heading in the range [0, 360)
+func normaliseHeading(heading float64) float64 {
+	heading = math.Mod(heading, 360)
+	if heading < 0 {
+		heading += 360
+	}
+	return heading
+}
+
+// eastPositive returns value as positive if direction is east, negative if west
+func eastPositive(value float64, direction string) float64 {
+	if direction == "W" {
+		return -math.Abs(value)
+	}
+	return math.Abs(value)
+}
+
+// hdmParser converts magnetic heading to true heading using the declination
 type hdmParser struct {
-	count int
+	count       int
+	declination *declinationSource
 }
 type hdtParser struct {
 	count int
@@ -23,66 +294,268 @@ type hdtParser struct {
 type thsParser struct {
 	count int
 }
+
+// hdgParser converts magnetic sensor heading to true heading using the deviation and variation in the sentence.
+// The declination is used if the sentence has no variation.
 type hdgParser struct {
-	count int
+	count       int
+	declination *declinationSource
 }
 
-func (p *hdmParser) parseNMEA(sentence nmea.Sentence) (bool, error) {
+func (p *hdmParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
 	switch m := sentence.(type) {
 	case nmea.HDM:
-		debugPrintf("HDM: Heading : %f\n", m.Heading)
-		latest.Orientation = m.Heading
+		declination := p.declination.declination()
+		heading := normaliseHeading(m.Heading + declination)
+		debugPrintf("HDM: Heading : %f (magnetic %f declination %f)\n", heading, m.Heading, declination)
 		p.count++
-		return true, nil
+		return heading, true, nil
 	}
-	return false, nil
+	return 0, false, nil
 }
 
 func (p hdmParser) String() string {
 	return fmt.Sprintf("HDM: %d", p.count)
 }
 
-func (p *hdtParser) parseNMEA(sentence nmea.Sentence) (bool, error) {
+func (p *hdtParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
 	switch m := sentence.(type) {
 	case nmea.HDT:
 		debugPrintf("HDT: Heading : %f\n", m.Heading)
-		latest.Orientation = m.Heading
 		p.count++
-		return true, nil
+		return m.Heading, true, nil
 	}
-	return false, nil
+	return 0, false, nil
 }
 
 func (p hdtParser) String() string {
 	return fmt.Sprintf("HDT: %d", p.count)
 }
 
-func (p *thsParser) parseNMEA(sentence nmea.Sentence) (bool, error) {
+func (p *thsParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
 	switch m := sentence.(type) {
 	case nmea.THS:
 		debugPrintf("THS: Heading : %f\n", m.Heading)
-		latest.Orientation = m.Heading
 		p.count++
-		return true, nil
+		return m.Heading, true, nil
 	}
-	return false, nil
+	return 0, false, nil
 }
 
 func (p thsParser) String() string {
 	return fmt.Sprintf("THS: %d", p.count)
 }
 
-func (p *hdgParser) parseNMEA(sentence nmea.Sentence) (bool, error) {
+func (p *hdgParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
 	switch m := sentence.(type) {
 	case nmea.HDG:
-		debugPrintf("HDG: Heading : %f\n", m.Heading)
-		latest.Orientation = m.Heading
+		deviation := 0.0
+		if m.DeviationDirection != "" {
+			deviation = eastPositive(m.Deviation, m.DeviationDirection)
+		}
+		variation := 0.0
+		if m.VariationDirection != "" {
+			variation = eastPositive(m.Variation, m.VariationDirection)
+		} else {
+			variation = p.declination.declination()
+		}
+		heading := normaliseHeading(m.Heading + deviation + variation)
+		debugPrintf("HDG: Heading : %f (sensor %f deviation %f variation %f)\n", heading, m.Heading, deviation, variation)
 		p.count++
-		return true, nil
+		return heading, true, nil
 	}
-	return false, nil
+	return 0, false, nil
 }
 
 func (p hdgParser) String() string {
 	return fmt.Sprintf("HDG: %d", p.count)
 }
+
+// nmeaDepthParser is the interface parsing depth input
+type nmeaDepthParser interface {
+	// parseNMEA takes a nmea.Sentence and returns the depth in meters and true if new data, else false
+	parseNMEA(sentence nmea.Sentence) (float64, bool, error)
+	// String returns a string representing the current status
+	String() string
+}
+
+const (
+	metersPerFoot   = 0.3048
+	metersPerFathom = 1.8288
+	// seawaterDensity in kg/m3 is used to convert pressure to depth
+	seawaterDensity = 1025.0
+	gravity         = 9.80665
+	pascalPerBar    = 100000.0
+)
+
+type dptParser struct {
+	count int
+}
+type dbtParser struct {
+	count int
+}
+
+// xdrDepthParser uses depth (D) or pressure (P) measurements from XDR.
+// Pressure is treated as gauge pressure (zero at the surface).
+type xdrDepthParser struct {
+	count int
+	// name is the transducer name to use, empty means any
+	name string
+}
+
+func (p *dptParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.DPT:
+		debugPrintf("DPT: Depth : %f\n", m.Depth)
+		p.count++
+		return m.Depth, true, nil
+	}
+	return 0, false, nil
+}
+
+func (p dptParser) String() string {
+	return fmt.Sprintf("DPT: %d", p.count)
+}
+
+func (p *dbtParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.DBT:
+		debugPrintf("DBT: Depth : %f m %f ft %f F\n", m.DepthMeters, m.DepthFeet, m.DepthFathoms)
+		p.count++
+		// Not all devices fill in all units
+		if m.DepthMeters != 0 {
+			return m.DepthMeters, true, nil
+		} else if m.DepthFeet != 0 {
+			return m.DepthFeet * metersPerFoot, true, nil
+		}
+		return m.DepthFathoms * metersPerFathom, true, nil
+	}
+	return 0, false, nil
+}
+
+func (p dbtParser) String() string {
+	return fmt.Sprintf("DBT: %d", p.count)
+}
+
+func (p *xdrDepthParser) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
+	switch m := sentence.(type) {
+	case nmea.XDR:
+		for _, measurement := range m.Measurements {
+			if p.name != "" && !strings.EqualFold(p.name, measurement.TransducerName) {
+				continue
+			}
+			depth, ok, err := xdrMeasurementToDepth(measurement)
+			if err != nil {
+				return 0, false, err
+			}
+			if ok {
+				debugPrintf("XDR: Depth : %f (%s)\n", depth, measurement.TransducerName)
+				p.count++
+				return depth, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// xdrMeasurementToDepth converts depth and pressure measurements to depth in meters
+func xdrMeasurementToDepth(measurement nmea.XDRMeasurement) (float64, bool, error) {
+	switch measurement.TransducerType {
+	case nmea.TransducerDepthXDR:
+		switch measurement.Unit {
+		case "M", "":
+			return measurement.Value, true, nil
+		}
+		return 0, false, fmt.Errorf("XDR depth unit '%s' not supported (%s)", measurement.Unit, measurement.TransducerName)
+	case nmea.TransducerPressureXDR:
+		var pascal float64
+		switch measurement.Unit {
+		case "B":
+			pascal = measurement.Value * pascalPerBar
+		case "P":
+			pascal = measurement.Value
+		default:
+			return 0, false, fmt.Errorf("XDR pressure unit '%s' not supported (%s)", measurement.Unit, measurement.TransducerName)
+		}
+		return pascal / (seawaterDensity * gravity), true, nil
+	}
+	return 0, false, nil
+}
+
+func (p xdrDepthParser) String() string {
+	return fmt.Sprintf("XDR: %d", p.count)
+}
+
+// defaultHeadingTimeout is how long a heading source can be silent before the next source is used
+const defaultHeadingTimeout = 3 * time.Second
+
+// headingSelector is a nmeaHeadingParser using several heading sources in order of priority.
+// The first source which has been received within the timeout is used.
+type headingSelector struct {
+	names    []string
+	sources  []nmeaHeadingParser
+	lastSeen []time.Time
+	timeout  time.Duration
+	// offset in degrees is added to the heading to correct for the compass not being aligned with the bow
+	offset float64
+	// active is the index of the source in use, -1 if none
+	active int
+	now    func() time.Time
+}
+
+func newHeadingSelector(timeout time.Duration) *headingSelector {
+	return &headingSelector{timeout: timeout, active: -1, now: time.Now}
+}
+
+// add adds a heading source with lower priority than the already added sources
+func (h *headingSelector) add(name string, source nmeaHeadingParser) {
+	h.names = append(h.names, name)
+	h.sources = append(h.sources, source)
+	h.lastSeen = append(h.lastSeen, time.Time{})
+}
+
+func (h *headingSelector) fresh(index int, now time.Time) bool {
+	return !h.lastSeen[index].IsZero() && now.Sub(h.lastSeen[index]) <= h.timeout
+}
+
+func (h *headingSelector) parseNMEA(sentence nmea.Sentence) (float64, bool, error) {
+	for i, source := range h.sources {
+		heading, success, err := source.parseNMEA(sentence)
+		if err != nil {
+			return 0, false, err
+		}
+		if !success {
+			continue
+		}
+		now := h.now()
+		h.lastSeen[i] = now
+		// Ignore this source while a source with higher priority is still fresh
+		for higher := 0; higher < i; higher++ {
+			if h.fresh(higher, now) {
+				return 0, false, nil
+			}
+		}
+		if h.active != i {
+			debugPrintf("Heading source: %s", h.names[i])
+		}
+		h.active = i
+		return normaliseHeading(heading + h.offset), true, nil
+	}
+	return 0, false, nil
+}
+
+// activeName returns the name of the heading source in use
+func (h *headingSelector) activeName() string {
+	if h.active < 0 {
+		return "none"
+	}
+	return h.names[h.active]
+}
+
+func (h headingSelector) String() string {
+	descs := make([]string, 0, len(h.sources))
+	for _, source := range h.sources {
+		descs = append(descs, source.String())
+	}
+	return fmt.Sprintf("%s (active: %s)", strings.Join(descs, " "), h.activeName())
+}
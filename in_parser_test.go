@@ -2,7 +2,9 @@ package main
 
 import (
 	"testing"
+	"time"
 
+	"github.com/adrianmo/go-nmea"
 	"github.com/stretchr/testify/require"
 )
 
@@ -10,77 +12,378 @@ func TestParserInputHDG(t *testing.T) {
 	input := "$HCHDG,101.1,,,7.1,W*3C"
 
 	headingParser := &hdgParser{}
-	gotUpdate, err := parseNMEA([]byte(input), headingParser)
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
 	require.NoError(t, err)
 	require.True(t, gotUpdate)
 
 	require.Equal(t, "HDG: 1", headingParser.String())
 	require.Equal(t, 1, headingParser.count)
-	require.Equal(t, 101.1, latest.Orientation)
+	// Variation 7.1 W
+	require.InDelta(t, 94.0, in.latest.Orientation, 0.0001)
 }
 
 func TestParserInputHDT(t *testing.T) {
 	input := "$GPHDT,274.07,T*03"
 
 	headingParser := &hdtParser{}
-	gotUpdate, err := parseNMEA([]byte(input), headingParser)
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
 	require.NoError(t, err)
 	require.True(t, gotUpdate)
 
 	require.Equal(t, "HDT: 1", headingParser.String())
 	require.Equal(t, 1, headingParser.count)
-	require.Equal(t, 274.07, latest.Orientation)
+	require.Equal(t, 274.07, in.latest.Orientation)
 }
 
 func TestParserInputHDM(t *testing.T) {
 	input := "$HCHDM,277.19,M*13"
 
 	headingParser := &hdmParser{}
-	gotUpdate, err := parseNMEA([]byte(input), headingParser)
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
 	require.NoError(t, err)
 	require.True(t, gotUpdate)
 
 	require.Equal(t, "HDM: 1", headingParser.String())
 	require.Equal(t, 1, headingParser.count)
-	require.Equal(t, 277.19, latest.Orientation)
+	require.Equal(t, 277.19, in.latest.Orientation)
 }
 
 func TestParserInputTHS(t *testing.T) {
 	input := "$GPTHS,338.01,A*0E"
 
 	headingParser := &thsParser{}
-	gotUpdate, err := parseNMEA([]byte(input), headingParser)
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
 	require.NoError(t, err)
 	require.True(t, gotUpdate)
 
 	require.Equal(t, "THS: 1", headingParser.String())
 	require.Equal(t, 1, headingParser.count)
-	require.Equal(t, 338.01, latest.Orientation)
+	require.Equal(t, 338.01, in.latest.Orientation)
 }
 
 func TestParserInputGGA(t *testing.T) {
 	input := "$GPGGA,015540.000,3150.68378,N,11711.93139,E,1,17,0.6,0051.6,M,0.0,M,,*58"
 
 	headingParser := &thsParser{}
-	gotUpdate, err := parseNMEA([]byte(input), headingParser)
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
 	require.NoError(t, err)
 	require.True(t, gotUpdate)
 
 	require.Equal(t, "THS: 0", headingParser.String())
 	require.Equal(t, 0, headingParser.count)
 
-	require.InDelta(t, 31.84473, latest.Lat, 0.0001)
-	require.InDelta(t, 117.198856, latest.Lon, 0.0001)
-	require.Equal(t, 1.0, latest.FixQuality)
-	require.Equal(t, 0.6, latest.Hdop)
-	require.Equal(t, 17.0, latest.NumSats)
+	require.InDelta(t, 31.84473, in.latest.Lat, 0.0001)
+	require.InDelta(t, 117.198856, in.latest.Lon, 0.0001)
+	require.Equal(t, 1.0, in.latest.FixQuality)
+	require.Equal(t, 0.6, in.latest.Hdop)
+	require.Equal(t, 17.0, in.latest.NumSats)
 }
 
 func TestParserInvalid(t *testing.T) {
 	input := "$GPGGA,*58"
 
 	headingParser := &hdmParser{}
-	gotUpdate, err := parseNMEA([]byte(input), headingParser)
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
 	require.NoError(t, err)
 	require.False(t, gotUpdate)
 }
+
+func TestParserInputHeadingValue(t *testing.T) {
+	headingParser := &hdtParser{}
+	input := "$GPHDT,274.07,T*03"
+	heading, gotUpdate, err := headingParser.parseNMEA(mustParse(t, input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 274.07, heading)
+
+	// Other sentences are ignored
+	input = "$HCHDM,277.19,M*13"
+	_, gotUpdate, err = headingParser.parseNMEA(mustParse(t, input))
+	require.NoError(t, err)
+	require.False(t, gotUpdate)
+	require.Equal(t, 1, headingParser.count)
+}
+
+func mustParse(t *testing.T, input string) nmea.Sentence {
+	s, err := nmea.Parse(input)
+	require.NoError(t, err)
+	return s
+}
+
+func TestParserInputDepthDPT(t *testing.T) {
+	input := "$SDDPT,12.5,0.5,*48"
+
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	depthParser := &dptParser{}
+	in.enableDepth(depthParser, 1.5)
+	gotUpdate, err := in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	// Depth is not a position/heading update
+	require.False(t, gotUpdate)
+
+	require.Equal(t, "DPT: 1", depthParser.String())
+	depth := <-in.depthCh
+	require.Equal(t, 14.0, depth.Depth)
+}
+
+func TestParserInputDepthDBT(t *testing.T) {
+	depthParser := &dbtParser{}
+	depth, gotUpdate, err := depthParser.parseNMEA(mustParse(t, "$SDDBT,41.0,f,12.5,M,6.8,F*0B"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 12.5, depth)
+
+	// Only feet
+	depth, gotUpdate, err = depthParser.parseNMEA(mustParse(t, "$SDDBT,32.8,f,,M,,F*3F"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 10.0, depth, 0.01)
+	require.Equal(t, "DBT: 2", depthParser.String())
+}
+
+func TestParserInputDepthXDR(t *testing.T) {
+	depthParser := &xdrDepthParser{}
+	depth, gotUpdate, err := depthParser.parseNMEA(mustParse(t, "$YXXDR,P,1.2345,B,DEPTH_PRESSURE*55"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 12.28, depth, 0.01)
+
+	// Temperature is skipped
+	depth, gotUpdate, err = depthParser.parseNMEA(mustParse(t, "$YXXDR,C,12.0,C,TEMP,D,7.25,M,DEPTH*04"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 7.25, depth)
+
+	_, gotUpdate, err = depthParser.parseNMEA(mustParse(t, "$YXXDR,P,100,K,BAD*22"))
+	require.Error(t, err)
+	require.False(t, gotUpdate)
+
+	// Only the named transducer is used
+	depthParser = &xdrDepthParser{name: "depth"}
+	_, gotUpdate, err = depthParser.parseNMEA(mustParse(t, "$YXXDR,P,1.2345,B,DEPTH_PRESSURE*55"))
+	require.NoError(t, err)
+	require.False(t, gotUpdate)
+	depth, gotUpdate, err = depthParser.parseNMEA(mustParse(t, "$YXXDR,C,12.0,C,TEMP,D,7.25,M,DEPTH*04"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 7.25, depth)
+}
+
+func TestParserInputGNS(t *testing.T) {
+	input := "$GNGNS,014035.00,4332.69262,S,17235.48549,E,RR,13,0.9,25.63,11.24,,*70"
+
+	positionParser := &gnsParser{}
+	in := NewInput(nil, positionParser, &hdtParser{}, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+
+	require.Equal(t, "GNS: 1", positionParser.String())
+	require.InDelta(t, -43.544877, in.latest.Lat, 0.0001)
+	require.InDelta(t, 172.591425, in.latest.Lon, 0.0001)
+	require.Equal(t, 4.0, in.latest.FixQuality)
+	require.Equal(t, 0.9, in.latest.Hdop)
+	require.Equal(t, 13.0, in.latest.NumSats)
+}
+
+func TestParserInputRMC(t *testing.T) {
+	input := "$GPRMC,220516,A,5133.82,N,00042.24,W,173.8,231.8,130694,004.2,W*70"
+
+	positionParser := &rmcParser{}
+	in := NewInput(nil, positionParser, &hdtParser{}, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+
+	require.Equal(t, "RMC: 1", positionParser.String())
+	require.InDelta(t, 51.563666, in.latest.Lat, 0.0001)
+	require.InDelta(t, -0.704, in.latest.Lon, 0.0001)
+	require.Equal(t, 1.0, in.latest.FixQuality)
+	require.Equal(t, 231.8, in.latest.Cog)
+	require.Equal(t, 173.8, in.latest.Sog)
+}
+
+func TestParserInputGLL(t *testing.T) {
+	input := "$GPGLL,3926.7952,N,12000.5947,W,022732,A,A*58"
+
+	positionParser := &gllParser{}
+	in := NewInput(nil, positionParser, &hdtParser{}, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+
+	require.Equal(t, "GLL: 1", positionParser.String())
+	require.InDelta(t, 39.446586, in.latest.Lat, 0.0001)
+	require.InDelta(t, -120.009911, in.latest.Lon, 0.0001)
+	require.Equal(t, 1.0, in.latest.FixQuality)
+}
+
+func TestParserInputOtherPositionIgnored(t *testing.T) {
+	input := "$GPGGA,015540.000,3150.68378,N,11711.93139,E,1,17,0.6,0051.6,M,0.0,M,,*58"
+
+	positionParser := &rmcParser{}
+	in := NewInput(nil, positionParser, &hdtParser{}, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.False(t, gotUpdate)
+	require.Equal(t, 0.0, in.latest.Lat)
+}
+
+func TestParserInputCourseVTG(t *testing.T) {
+	input := "$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K,A*25"
+
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	gotUpdate, err := in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+
+	require.Equal(t, "VTG: 1 RMC: 0", in.courseParser.String())
+	require.Equal(t, 54.7, in.latest.Cog)
+	require.Equal(t, 5.5, in.latest.Sog)
+
+	// Speed only in km/h
+	input = "$GPVTG,231.8,T,,M,,N,18.52,K,A*25"
+	gotUpdate, err = in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 231.8, in.latest.Cog)
+	require.InDelta(t, 10.0, in.latest.Sog, 0.0001)
+
+	// Data not valid
+	input = "$GPVTG,,T,,M,0.0,N,0.0,K,N*2C"
+	gotUpdate, err = in.parseNMEA([]byte(input))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, "VTG: 3 RMC: 0", in.courseParser.String())
+	require.Equal(t, 0.0, in.latest.Cog)
+	require.Equal(t, 0.0, in.latest.Sog)
+}
+
+func TestParserInputCourseRMC(t *testing.T) {
+	// Course from RMC is used even if position is from GGA
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	gotUpdate, err := in.parseNMEA([]byte("$GPRMC,220516,A,5133.82,N,00042.24,W,173.8,231.8,130694,004.2,W*70"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 231.8, in.latest.Cog)
+	require.Equal(t, 173.8, in.latest.Sog)
+	require.Equal(t, 0.0, in.latest.Lat)
+
+	gotUpdate, err = in.parseNMEA([]byte("$GPRMC,220516,V,5133.82,N,00042.24,W,173.8,231.8,130694,004.2,W*67"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, "VTG: 0 RMC: 2", in.courseParser.String())
+	require.Equal(t, 0.0, in.latest.Cog)
+	require.Equal(t, 0.0, in.latest.Sog)
+}
+
+func TestHeadingSelectorFailover(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	selector := newHeadingSelector(2 * time.Second)
+	selector.now = func() time.Time { return now }
+	selector.add("THS", &thsParser{})
+	selector.add("HDM", &hdmParser{})
+	require.Equal(t, "THS: 0 HDM: 0 (active: none)", selector.String())
+
+	ths := mustParse(t, "$GPTHS,338.01,A*0E")
+	hdm := mustParse(t, "$HCHDM,277.19,M*13")
+
+	// Lower priority is used when higher priority has not been received
+	heading, gotUpdate, err := selector.parseNMEA(hdm)
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 277.19, heading)
+	require.Equal(t, "HDM", selector.activeName())
+
+	// Higher priority takes over
+	heading, gotUpdate, err = selector.parseNMEA(ths)
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 338.01, heading)
+	require.Equal(t, "THS", selector.activeName())
+
+	// Lower priority is ignored while higher priority is fresh
+	now = now.Add(2 * time.Second)
+	_, gotUpdate, err = selector.parseNMEA(hdm)
+	require.NoError(t, err)
+	require.False(t, gotUpdate)
+	require.Equal(t, "THS", selector.activeName())
+
+	// Higher priority is stale
+	now = now.Add(1 * time.Millisecond)
+	heading, gotUpdate, err = selector.parseNMEA(hdm)
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.Equal(t, 277.19, heading)
+	require.Equal(t, "THS: 1 HDM: 3 (active: HDM)", selector.String())
+}
+
+func TestHeadingSelectorOffset(t *testing.T) {
+	selector := newHeadingSelector(defaultHeadingTimeout)
+	selector.offset = -90
+	selector.add("HDT", &hdtParser{})
+
+	heading, gotUpdate, err := selector.parseNMEA(mustParse(t, "$GPHDT,45.0,T*04"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 315.0, heading, 1e-9)
+}
+
+func TestParserInputMagneticDeclination(t *testing.T) {
+	declination := newDeclinationSource(2.5, false)
+
+	hdm := &hdmParser{declination: declination}
+	heading, gotUpdate, err := hdm.parseNMEA(mustParse(t, "$HCHDM,359.5,M*23"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 2.0, heading, 0.0001)
+
+	// HDG without variation uses the declination
+	hdg := &hdgParser{declination: declination}
+	heading, gotUpdate, err = hdg.parseNMEA(mustParse(t, "$HCHDG,355.0,2.0,E,,*28"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 359.5, heading, 0.0001)
+
+	// HDG with variation ignores the declination
+	heading, gotUpdate, err = hdg.parseNMEA(mustParse(t, "$HCHDG,10.0,1.5,W,3.0,W*74"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 5.5, heading, 0.0001)
+}
+
+func TestParserInputMagneticWMM(t *testing.T) {
+	declination := newDeclinationSource(100, true)
+	declination.now = func() time.Time { return time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC) }
+	headingParser := &hdmParser{declination: declination}
+
+	in := NewInput(nil, &ggaParser{}, headingParser, nil)
+	in.declination = declination
+
+	// Fixed declination is used until position is known
+	gotUpdate, err := in.parseNMEA([]byte("$HCHDM,277.19,M*13"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 17.19, in.latest.Orientation, 0.0001)
+
+	// Declination in Hefei is about 5.5 degrees west
+	gotUpdate, err = in.parseNMEA([]byte("$GPGGA,015540.000,3150.68378,N,11711.93139,E,1,17,0.6,0051.6,M,0.0,M,,*58"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	gotUpdate, err = in.parseNMEA([]byte("$HCHDM,277.19,M*13"))
+	require.NoError(t, err)
+	require.True(t, gotUpdate)
+	require.InDelta(t, 277.19-5.5, in.latest.Orientation, 1)
+}
+
+func TestNormaliseHeading(t *testing.T) {
+	require.Equal(t, 0.0, normaliseHeading(360))
+	require.Equal(t, 350.0, normaliseHeading(-10))
+	require.Equal(t, 10.0, normaliseHeading(370))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/waterlinked/ugps-go/ugps"
+)
+
+func TestInputSendsToUGPS(t *testing.T) {
+	received := make(chan ugps.ExternalMaster, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/api/v1/external/master", r.URL.Path)
+		var ext ugps.ExternalMaster
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&ext))
+		received <- ext
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := NewInput(ugps.NewClient(srv.URL), &ggaParser{}, &hdtParser{}, nil)
+	done := make(chan struct{})
+	go func() {
+		in.Loop(ctx)
+		close(done)
+	}()
+
+	in.handleData(ctx, []byte("$GPHDT,274.07,T*03"))
+	<-in.inputStatusChannel
+	select {
+	case ext := <-received:
+		require.Equal(t, 274.07, ext.Orientation)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Nothing sent to UGPS")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Input loop did not stop")
+	}
+}
+
+func TestLeverArm(t *testing.T) {
+	master := ugps.ExternalMaster{Lat: 60, Lon: 10, Orientation: 90}
+
+	// No lever arm does not move the position
+	assert.Equal(t, master, leverArm{}.apply(master))
+
+	// 10 m forward when heading east
+	moved := leverArm{X: 10}.apply(master)
+	assert.InDelta(t, 60, moved.Lat, 1e-9)
+	assert.InDelta(t, 10+10/(earthRadius*0.5)*180/math.Pi, moved.Lon, 1e-9)
+	assert.Equal(t, 90.0, moved.Orientation)
+
+	// 10 m to starboard when heading east is south
+	moved = leverArm{Y: 10}.apply(master)
+	assert.InDelta(t, 60-10.0/earthRadius*180/math.Pi, moved.Lat, 1e-9)
+	assert.InDelta(t, 10, moved.Lon, 1e-9)
+}
+
+func TestInputTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	conn := newInputConn(device{scheme: schemeTCP, address: ln.Addr().String()})
+	context.AfterFunc(ctx, func() { conn.Close() })
+	in.conn = conn
+	done := make(chan struct{})
+	go func() {
+		in.StreamLoop(ctx, conn)
+		close(done)
+	}()
+
+	server, err := ln.Accept()
+	require.NoError(t, err)
+	_, err = server.Write([]byte("$GPHDT,274.07,T*03\r\n"))
+	require.NoError(t, err)
+
+	stats := <-in.inputStatusChannel
+	assert.Equal(t, "HDT: 1", stats.src.headDesc)
+	assert.Equal(t, connectionStatus{connected: true}, stats.conn)
+	ext := <-in.masterCh
+	assert.Equal(t, 274.07, ext.Orientation)
+
+	// The connection is reopened when lost
+	server.Close()
+	stats = <-in.inputStatusChannel
+	assert.False(t, stats.conn.connected)
+	assert.Contains(t, stats.src.errorMsg, "connection closed")
+
+	server, err = ln.Accept()
+	require.NoError(t, err)
+	defer server.Close()
+	_, err = server.Write([]byte("$GPHDT,274.07,T*03\r\n"))
+	require.NoError(t, err)
+	stats = <-in.inputStatusChannel
+	assert.Equal(t, "HDT: 2", stats.src.headDesc)
+	assert.Equal(t, connectionStatus{connected: true, reconnects: 1}, stats.conn)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("TCP loop did not stop")
+	}
+}
+
+func TestInputRetransmitLines(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var retransmitted bytes.Buffer
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, &retransmitted)
+	go func() {
+		for {
+			select {
+			case <-in.inputStatusChannel:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Lines are retransmitted with CR LF, also when received with LF only
+	err := in.readLines(ctx, strings.NewReader("$GPHDT,274.07,T*03\r\n$GPHDT,275.07,T*02\n"))
+	assert.EqualError(t, err, "connection closed")
+	assert.Equal(t, "$GPHDT,274.07,T*03\r\n$GPHDT,275.07,T*02\r\n", retransmitted.String())
+}
+
+func TestInputTCPListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	done := make(chan struct{})
+	go func() {
+		in.TCPListenLoop(ctx, ln)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("$GPHDT,274.07,T*03\r\n"))
+	require.NoError(t, err)
+
+	ext := <-in.masterCh
+	assert.Equal(t, 274.07, ext.Orientation)
+	stats := <-in.inputStatusChannel
+	assert.Equal(t, connectionStatus{listening: true, clients: 1}, stats.conn)
+	assert.Equal(t, "listening, clients: 1", stats.conn.String())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("TCP listen loop did not stop")
+	}
+}
+
+func TestInputTCPListenAcceptError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := NewInput(nil, &ggaParser{}, &hdtParser{}, nil)
+	ln := &failingListener{}
+	done := make(chan struct{})
+	go func() {
+		in.TCPListenLoop(ctx, ln)
+		close(done)
+	}()
+
+	// The error is reported and accept is retried after a delay
+	stats := <-in.inputStatusChannel
+	assert.Contains(t, stats.src.errorMsg, "Error accepting clients on 127.0.0.1:10110")
+	assert.True(t, stats.conn.listening)
+	assert.NotEmpty(t, stats.conn.errMsg)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), ln.accepts.Load())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("TCP listen loop did not stop")
+	}
+}
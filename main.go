@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,15 +10,19 @@ import (
 	"net"
 	neturl "net/url"
 	"os"
-	"strconv"
+	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"go.bug.st/serial"
+	"github.com/waterlinked/ugps-go/ugps"
 )
 
 var (
-	debug bool
+	debug    bool
+	headless bool
 
 	Version  string = "0.0.0"
 	BuildNum string = "local"
@@ -36,29 +41,21 @@ func debugPrintf(arguments string, a ...interface{}) {
 		s := time.Now().Format("15:04:05") + " " + fmt.Sprintf(arguments, a...)
 		dbgMsg = append(dbgMsg, strings.TrimSpace(s))
 		//log.Printf(arguments, a...)
+		if headless {
+			logger.Debug(strings.TrimSpace(fmt.Sprintf(arguments, a...)))
+		}
 	}
 }
 
-// deviceIsUDP uses ":" to decide if this is UDP address or serial device
-func deviceIsUDP(device string) bool {
-	return len(strings.Split(device, ":")) > 1
-}
-
-func baudAndPortFromDevice(device string) (string, int) {
-	baudrate := 115200
-	port := device
-	// Is the baudrate specified?
-	parts := strings.Split(device, "@")
-	if len(parts) > 1 {
-		b, err := strconv.Atoi(parts[1])
-		if err != nil {
-			fmt.Printf("Unable to parse baudrate: %s as numeric value\n", parts[1])
-			os.Exit(1)
-		}
-		baudrate = b
-		port = parts[0]
+// exitWithError shows the message to the user and exits with a non-zero exit code.
+// In headless mode the message is written to stderr instead of the UI.
+func exitWithError(message string) {
+	if headless {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(message))
+	} else {
+		RunUIError(message)
 	}
-	return port, baudrate
+	os.Exit(1)
 }
 
 func keys[V any](m map[string]V) string {
@@ -76,36 +73,96 @@ func applicationName() string {
 
 func main() {
 	var (
-		listen          string
-		headingSentence string
-		output          string
-		sentence        string
-		url             string
-		cfgFilename     string
+		listen           string
+		positionSentence string
+		headingSentence  string
+		output           string
+		sentence         string
+		talker           string
+		url              string
+		cfgFilename      string
+		listPorts        bool
 	)
 
+	// The talker ID of output sentences is configured per output
 	availableSerialisers := make(map[string]nmeaPositionSerialiser)
-	availableSerialisers["RATLL"] = tllSerialiser{}
-	availableSerialisers["GPGGA"] = ggaSerialiser{}
+	availableSerialisers["TLL"] = tllSerialiser{}
+	availableSerialisers["GGA"] = ggaSerialiser{}
+	availableSerialisers["GLL"] = gllSerialiser{}
+	availableSerialisers["RMC"] = rmcSerialiser{}
+	availableSerialisers["GNS"] = gnsSerialiser{}
+	availableSerialisers["VTG"] = vtgSerialiser{}
+	availableSerialisers["TTM"] = ttmSerialiser{}
+	availableSerialisers["DPT"] = dptSerialiser{}
+	availableSerialisers["DBT"] = dbtSerialiser{}
+	availableSerialisers["XDR"] = xdrSerialiser{}
+	availableSerialisers["PWLDEP"] = pwlDepSerialiser{}
+	availableSerialisers["PWLPOS"] = pwlPosSerialiser{}
+	// AIS settings are configured after the config file is read
+	ais := &aisSettings{messageType: aisPositionReportClassB}
+	availableSerialisers["VDM"] = aisSerialiser{sentence: "VDM", settings: ais}
+	availableSerialisers["VDO"] = aisSerialiser{sentence: "VDO", settings: ais}
 	supportedSentences := keys(availableSerialisers)
+	// Sentence names from before the talker ID was configurable
+	serialiserAliases := map[string]string{"RATLL": "TLL", "GPGGA": "GGA"}
+
+	// NMEA 2000 output PGNs
+	availablePGNs := make(map[string]n2kPGNSerialiser)
+	availablePGNs["129025"] = n2kRapidPosition{}
+	availablePGNs["129029"] = n2kGNSSPosition{}
+	availablePGNs["128267"] = n2kWaterDepth{}
+	availablePGNs["129284"] = n2kNavigationData{}
+	supportedPGNs := keys(availablePGNs)
+
+	availablePositionSentences := make(map[string]nmeaPositionParser)
+	availablePositionSentences["GGA"] = &ggaParser{}
+	availablePositionSentences["GNS"] = &gnsParser{}
+	availablePositionSentences["RMC"] = &rmcParser{}
+	availablePositionSentences["GLL"] = &gllParser{}
+	supportedPositions := keys(availablePositionSentences)
+
+	// Declination is configured after the config file is read
+	declination := newDeclinationSource(0, false)
 
 	availableHeadingSentences := make(map[string]nmeaHeadingParser)
-	availableHeadingSentences["HDM"] = &hdmParser{}
+	availableHeadingSentences["HDM"] = &hdmParser{declination: declination}
 	availableHeadingSentences["HDT"] = &hdtParser{}
 	availableHeadingSentences["THS"] = &thsParser{}
-	availableHeadingSentences["HDG"] = &hdgParser{}
+	availableHeadingSentences["HDG"] = &hdgParser{declination: declination}
 	supportedHeadings := keys(availableHeadingSentences)
 
+	availableDepthSentences := make(map[string]nmeaDepthParser)
+	availableDepthSentences["DPT"] = &dptParser{}
+	availableDepthSentences["DBT"] = &dbtParser{}
+	availableDepthSentences["XDR"] = &xdrDepthParser{}
+	supportedDepths := keys(availableDepthSentences)
+
 	fmt.Println(applicationName())
-	flag.StringVar(&listen, "i", "", "UDP device and port (host:port) OR serial device (COM7 /dev/ttyUSB1@4800) to listen for NMEA input. ")
-	flag.StringVar(&output, "o", "", "UDP device and port (host:port) OR serial device (COM7 /dev/ttyUSB1) to send NMEA output. ")
+	flag.StringVar(&listen, "i", "", "UDP device and port (host:port) OR serial device (COM7 /dev/ttyUSB1@4800) OR url (tcp://host:port, tcp-listen://:port, udp://:port, serial:///dev/ttyUSB1?baud=4800&parity=even, socketcan://can0) to listen for NMEA input. ")
+	flag.StringVar(&output, "o", "", "UDP device and port (host:port) OR serial device (COM7 /dev/ttyUSB1) OR url (tcp://host:port, tcp-listen://:port, udp://host:port, serial:///dev/ttyUSB1?baud=4800) to send NMEA output. ")
 	flag.StringVar(&sentence, "sentence", "GPGGA", "NMEA output sentence to use. Supported: "+supportedSentences)
-	flag.StringVar(&headingSentence, "heading", "HDT", "Input sentence type to use for heading. Supported: "+supportedHeadings)
+	flag.StringVar(&talker, "talker", "", "Talker ID of the NMEA output sentences, eg. GP, GN or II. Default is GP, RA for TLL and TTM, SD for DPT and DBT, YX for XDR and AI for VDM and VDO")
+	flag.StringVar(&positionSentence, "position", "GGA", "Input sentence type to use for position. Supported: "+supportedPositions)
+	flag.StringVar(&headingSentence, "heading", "HDT", "Input sentence type to use for heading, comma separated in order of priority. Supported: "+supportedHeadings)
 	flag.StringVar(&url, "url", "http://192.168.2.94", "URL of Underwater GPS")
 	flag.StringVar(&cfgFilename, "c", "config.yml", "Configuration file to use")
 	flag.BoolVar(&debug, "d", false, "debug")
+	flag.BoolVar(&headless, "headless", false, "Run without the terminal UI and log status to stdout (for systemd, containers etc)")
+	flag.BoolVar(&listPorts, "list-ports", false, "List serial ports with USB vendor id, product id, serial number and product, and exit")
 	flag.Parse()
 
+	if listPorts {
+		if err := listSerialPorts(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed listing serial ports: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if headless {
+		setupHeadlessLogger()
+	}
+
 	cfgSource := fmt.Sprintf("config file '%s'", cfgFilename)
 	cfg := Config{}
 	err := readFile(&cfg, cfgFilename)
@@ -115,131 +172,342 @@ func main() {
 			fmt.Printf("no config file can be loaded ('%s').\nusing command line arguments\n", err)
 			cfgSource = "command line arguments"
 			cfg.Input.Device = listen
+			cfg.Input.PositionSentence = positionSentence
 			cfg.Input.HeadingSentence = headingSentence
-			cfg.Output.Device = output
-			cfg.Output.PositionSentence = sentence
+			cfg.Output = outputConfigs{{Device: output, PositionSentence: sentence, Talker: talker}}
 			cfg.BaseURL = url
 		} else {
-			RunUIError(fmt.Sprintf("config file parse error:\n%s", err))
-			os.Exit(1)
+			exitWithError(fmt.Sprintf("config file parse error:\n%s", err))
 		}
 	}
 
-	baseURL = cfg.BaseURL
-	u, err := neturl.Parse(baseURL)
+	u, err := neturl.Parse(cfg.BaseURL)
 	if err != nil {
-		RunUIError(fmt.Sprintf("Url should be in form http://1.2.3.4. Got '%s': %s", baseURL, err))
-		os.Exit(1)
+		exitWithError(fmt.Sprintf("Url should be in form http://1.2.3.4. Got '%s': %s", cfg.BaseURL, err))
 	}
 	if u.Scheme == "" {
-		RunUIError(fmt.Sprintf("Url should be in form http://1.2.3.4. Got '%s'", baseURL))
-		os.Exit(1)
+		exitWithError(fmt.Sprintf("Url should be in form http://1.2.3.4. Got '%s'", cfg.BaseURL))
 	}
+	ugpsClient := ugps.NewClient(cfg.BaseURL)
 
-	// Same serial port for input and output?
-	sameInOut := (cfg.Input.Device == cfg.Output.Device) && !deviceIsUDP(cfg.Input.Device)
-	if sameInOut {
-		fmt.Println("Same port for input and output", cfg.Input.Device)
+	if cfg.Input.LeverArm.Z != 0 {
+		exitWithError(fmt.Sprintf("Lever arm z is not supported as the Underwater GPS does not use the antenna height, set it to 0. Got: %g\n", cfg.Input.LeverArm.Z))
 	}
 
-	serialiser, exists := availableSerialisers[strings.ToUpper(cfg.Output.PositionSentence)]
-	if !exists {
-		msg := fmt.Sprintf("Unsupported sentence '%s'. Supported are: %s\n", cfg.Output.PositionSentence, supportedSentences)
-		RunUIError(msg)
+	var inputDevice, retransmitDevice device
+	// n2kInputFormat is the gateway format of NMEA 2000 input, empty for SocketCAN
+	var n2kInputFormat n2kInputFormat
+	if cfg.InputEnabled() {
+		inputDevice, err = parseDevice(cfg.Input.Device)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid input device: %s\n", err))
+		}
+		format := strings.ToLower(cfg.Input.Format)
+		n2kFormat, isN2K := n2kInputFormats[format]
+		if inputDevice.scheme == schemeSocketCAN {
+			if format != "" {
+				exitWithError(fmt.Sprintf("Format can not be set for SocketCAN input %s, it is always NMEA 2000\n", cfg.Input.Device))
+			}
+			if cfg.RetransmitEnabled() {
+				exitWithError(fmt.Sprintf("Retransmit is not supported for SocketCAN input %s\n", cfg.Input.Device))
+			}
+		} else if !isN2K && format != "" && format != formatNMEA0183 {
+			exitWithError(fmt.Sprintf("Unsupported format '%s' for input %s. Supported are: %s, %s\n", cfg.Input.Format, cfg.Input.Device, formatNMEA0183, keys(n2kInputFormats)))
+		}
+		if cfg.InputIsN2K() {
+			if cfg.DepthEnabled() {
+				exitWithError("Depth input is only supported for NMEA 0183 input\n")
+			}
+			n2kInputFormat = n2kFormat
+		}
+	}
+	if cfg.RetransmitEnabled() {
+		retransmitDevice, err = parseDevice(cfg.Input.Retransmit)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid retransmit device: %s\n", err))
+		}
+	}
 
-		os.Exit(1)
+	targets := []outputTarget{defaultTarget}
+	if len(cfg.Targets) > 0 {
+		targets = nil
+	}
+	for _, target := range cfg.Targets {
+		endpoint, exists := targetEndpoints[strings.ToLower(target.Endpoint)]
+		if !exists {
+			if !strings.HasPrefix(target.Endpoint, "/") {
+				exitWithError(fmt.Sprintf("Invalid target endpoint '%s'. Use %s or the path of a UGPS position endpoint\n", target.Endpoint, keys(targetEndpoints)))
+			}
+			endpoint = target.Endpoint
+		}
+		if target.Number < 0 || target.Number > 99 {
+			exitWithError(fmt.Sprintf("Target number for %s should be 0-99, got: %d\n", target.Endpoint, target.Number))
+		}
+		if slices.ContainsFunc(targets, func(t outputTarget) bool { return t.number == target.Number }) {
+			exitWithError(fmt.Sprintf("Target number %d is used more than once\n", target.Number))
+		}
+		if target.MMSI < 0 || target.MMSI > aisMaxMMSI {
+			exitWithError(fmt.Sprintf("Target MMSI for %s should be up to 9 digits, got: %d\n", target.Endpoint, target.MMSI))
+		}
+		if target.MMSI != 0 && slices.ContainsFunc(targets, func(t outputTarget) bool { return t.mmsi == target.MMSI }) {
+			exitWithError(fmt.Sprintf("Target MMSI %d is used more than once\n", target.MMSI))
+		}
+		targets = append(targets, outputTarget{endpoint: endpoint, number: target.Number, name: target.Name, mmsi: target.MMSI})
+	}
+
+	switch cfg.AIS.MessageType {
+	case 0:
+	case aisPositionReportClassA, aisPositionReportClassB:
+		ais.messageType = cfg.AIS.MessageType
+	default:
+		exitWithError(fmt.Sprintf("AIS message type should be %d or %d, got: %d\n", aisPositionReportClassA, aisPositionReportClassB, cfg.AIS.MessageType))
 	}
 
-	hParser, exists := availableHeadingSentences[strings.ToUpper(cfg.Input.HeadingSentence)]
+	outputs := cfg.EnabledOutputs()
+	outputDevices := make([]device, len(outputs))
+	outputEncoders := make([]positionEncoder, len(outputs))
+	for i, output := range outputs {
+		outputDevices[i], err = parseDevice(output.Device)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid output device: %s\n", err))
+		}
+		if output.Rate < 0 {
+			exitWithError(fmt.Sprintf("Output rate for %s can not be negative: %v\n", output.Device, output.Rate))
+		}
+
+		format := strings.ToLower(output.Format)
+		encoding, isN2K := n2kEncodings[format]
+		if outputDevices[i].scheme == schemeSocketCAN {
+			if format != "" {
+				exitWithError(fmt.Sprintf("Format can not be set for SocketCAN output %s, it is always NMEA 2000\n", output.Device))
+			}
+			encoding, isN2K = encodeSocketCAN, true
+		} else if !isN2K && format != "" && format != formatNMEA0183 {
+			exitWithError(fmt.Sprintf("Unsupported format '%s' for output %s. Supported are: %s, %s\n", output.Format, output.Device, formatNMEA0183, keys(n2kEncodings)))
+		}
+		if isN2K {
+			var pgns []n2kPGNSerialiser
+			for _, name := range output.PositionSentences() {
+				serialiser, exists := availablePGNs[name]
+				if !exists {
+					exitWithError(fmt.Sprintf("Unsupported PGN '%s' for NMEA 2000 output %s. Supported are: %s\n", name, output.Device, supportedPGNs))
+				}
+				pgns = append(pgns, serialiser)
+			}
+			outputEncoders[i] = newN2KPGNs(pgns, encoding)
+			continue
+		}
+
+		if len(output.PositionSentences()) == 0 {
+			exitWithError(fmt.Sprintf("No sentence configured for output %s. Supported are: %s\n", output.Device, supportedSentences))
+		}
+		outputTalker := strings.ToUpper(output.Talker)
+		if outputTalker != "" && !validTalker(outputTalker) {
+			exitWithError(fmt.Sprintf("Invalid talker ID '%s' for output %s. It should be two letters, eg. GP, GN or II\n", output.Talker, output.Device))
+		}
+		var sentences nmeaSentences
+		// onlyProprietary is true if none of the sentences use the talker ID
+		onlyProprietary := true
+		for _, name := range output.PositionSentences() {
+			key := strings.ToUpper(name)
+			if alias, exists := serialiserAliases[key]; exists {
+				key = alias
+			}
+			serialiser, exists := availableSerialisers[key]
+			if !exists {
+				msg := fmt.Sprintf("Unsupported sentence '%s'. Supported are: %s\n", name, supportedSentences)
+				exitWithError(msg)
+			}
+			if _, ok := serialiser.(proprietarySerialiser); !ok {
+				onlyProprietary = false
+			}
+			serialiser = serialiser.withTalker(outputTalker)
+			if s, ok := serialiser.(targetSerialiser); ok {
+				// One sentence for each target
+				for _, target := range targets {
+					if _, isAIS := serialiser.(aisSerialiser); isAIS && target.mmsi == 0 {
+						exitWithError(fmt.Sprintf("AIS output %s needs the mmsi of target %d (%s), set mmsi in targets\n", output.Device, target.number, target.name))
+					}
+					sentences = append(sentences, s.withTarget(target))
+				}
+				continue
+			}
+			sentences = append(sentences, serialiser)
+		}
+		if outputTalker != "" && onlyProprietary {
+			exitWithError(fmt.Sprintf("Talker ID '%s' is not used for output %s, proprietary sentences have no talker ID\n", output.Talker, output.Device))
+		}
+		outputEncoders[i] = sentences
+	}
+
+	// sameAsInput returns true if the output is to the same serial port as the input
+	sameAsInput := func(output device) bool {
+		return cfg.InputEnabled() && inputDevice.scheme == schemeSerial && output.scheme == schemeSerial &&
+			inputDevice.address == output.address && inputDevice.usb == output.usb
+	}
+	for _, output := range outputDevices {
+		if sameAsInput(output) {
+			fmt.Println("Same port for input and output", inputDevice.name())
+		}
+	}
+
+	if cfg.Input.PositionSentence == "" {
+		// Config files from before the position sentence was configurable
+		cfg.Input.PositionSentence = "GGA"
+	}
+	pParser, exists := availablePositionSentences[strings.ToUpper(cfg.Input.PositionSentence)]
 	if !exists {
-		msg := fmt.Sprintf("Unsupported heading sentence '%s'. Supported are: %s\n", cfg.Input.HeadingSentence, supportedHeadings)
-		RunUIError(msg)
-		os.Exit(1)
+		msg := fmt.Sprintf("Unsupported position sentence '%s'. Supported are: %s\n", cfg.Input.PositionSentence, supportedPositions)
+		exitWithError(msg)
 	}
 
-	// Channels
-	inStatusCh := make(chan inputStats, 1)
-	masterCh := make(chan externalMaster, 1)
+	declination.fixed = cfg.Input.Magnetic.Declination
+	declination.useWMM = cfg.Input.Magnetic.WMM
+
+	headingTimeout := defaultHeadingTimeout
+	if cfg.Input.HeadingTimeout > 0 {
+		headingTimeout = time.Duration(cfg.Input.HeadingTimeout * float64(time.Second))
+	}
+	hParser := newHeadingSelector(headingTimeout)
+	hParser.offset = cfg.Input.HeadingOffset
+	for _, name := range cfg.HeadingSources() {
+		name = strings.ToUpper(name)
+		source, exists := availableHeadingSentences[name]
+		if !exists {
+			msg := fmt.Sprintf("Unsupported heading sentence '%s'. Supported are: %s\n", name, supportedHeadings)
+			exitWithError(msg)
+		}
+		if slices.Contains(hParser.names, name) {
+			exitWithError(fmt.Sprintf("Heading sentence '%s' is listed more than once\n", name))
+		}
+		hParser.add(name, source)
+	}
+	// NMEA 2000 input has heading in PGN 127250
+	if cfg.InputEnabled() && !cfg.InputIsN2K() && len(hParser.sources) == 0 {
+		exitWithError(fmt.Sprintf("No heading sentence configured. Supported are: %s\n", supportedHeadings))
+	}
 
-	// Output
-	var writer io.Writer = nil
+	var dParser nmeaDepthParser
+	if cfg.DepthEnabled() {
+		dParser, exists = availableDepthSentences[strings.ToUpper(cfg.Input.Depth.Sentence)]
+		if !exists {
+			msg := fmt.Sprintf("Unsupported depth sentence '%s'. Supported are: %s\n", cfg.Input.Depth.Sentence, supportedDepths)
+			exitWithError(msg)
+		}
+		if xdr, ok := dParser.(*xdrDepthParser); ok {
+			xdr.name = cfg.Input.Depth.XDRName
+		}
+	}
+
+	// Stop all loops on SIGINT/SIGTERM or when the UI quits
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	var wg sync.WaitGroup
+	run := func(loop func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop()
+		}()
+	}
+
+	// inputConn is used for output to the same serial port as the input
+	var inputConn *reconnectingConn
 
 	// Setup input
+	var input *Input
 	if cfg.InputEnabled() {
-		var retransmit net.Conn
+		var retransmit io.Writer
 		if cfg.RetransmitEnabled() {
-			if !deviceIsUDP(cfg.Input.Retransmit) {
-				msg := fmt.Sprintf("Retransmit only supports UDP. Got serial port as configuration: %v\n", cfg.Input.Retransmit)
-				RunUIError(msg)
-				os.Exit(1)
+			if !retransmitDevice.isNetwork() {
+				msg := fmt.Sprintf("Retransmit only supports network devices. Got serial port as configuration: %v\n", cfg.Input.Retransmit)
+				exitWithError(msg)
 			}
-			conn, err := net.Dial("udp", cfg.Input.Retransmit)
+			w, err := newOutputWriter(retransmitDevice)
 			if err != nil {
-				msg := fmt.Sprintf("Error connecting to UDP: %s:%v\n", err, cfg.Input.Retransmit)
-				RunUIError(msg)
-				os.Exit(1)
+				msg := fmt.Sprintf("Error opening retransmit %v: %s\n", cfg.Input.Retransmit, err)
+				exitWithError(msg)
 			}
-			defer conn.Close()
-			retransmit = conn
+			defer w.Close()
+			retransmit = w
 		}
-		if deviceIsUDP(cfg.Input.Device) {
-			// Input from UDP
-			go inputUDPLoop(cfg.Input.Device, hParser, masterCh, inStatusCh, retransmit)
-		} else {
-			// Input from serial port
-			port, baudrate := baudAndPortFromDevice(cfg.Input.Device)
-
-			c := &serial.Mode{BaudRate: baudrate}
-			s, err := serial.Open(port, c)
+		input = NewInput(ugpsClient, pParser, hParser, retransmit)
+		input.declination = declination
+		input.leverArm = cfg.Input.LeverArm
+		if cfg.InputIsN2K() {
+			input.n2k = newN2KInput(n2kInputFormat, cfg.Input.HeadingOffset)
+		}
+		if dParser != nil {
+			input.enableDepth(dParser, cfg.Input.Depth.Offset)
+		}
+		switch inputDevice.scheme {
+		case schemeTCPListen:
+			ln, err := net.Listen("tcp", inputDevice.address)
 			if err != nil {
-				msg := fmt.Sprintf("Error opening serial port %s: %v\n", port, err)
-				RunUIError(msg)
-				os.Exit(1)
+				msg := fmt.Sprintf("Error listening on TCP %s: %v\n", inputDevice.address, err)
+				exitWithError(msg)
 			}
-			defer s.Close()
-
-			go inputSerialLoop(s, hParser, masterCh, inStatusCh, retransmit)
-			if sameInOut {
-				// Output is to same serial port as input
-				writer = s
+			run(func() { input.TCPListenLoop(ctx, ln) })
+		case schemeUDP, schemeTCP:
+			conn := newInputConn(inputDevice)
+			// Closing stops a blocking read when shutting down
+			context.AfterFunc(ctx, func() { conn.Close() })
+			input.conn = conn
+			if inputDevice.scheme == schemeUDP {
+				run(func() { input.UDPLoop(ctx, conn) })
+			} else {
+				run(func() { input.StreamLoop(ctx, conn) })
 			}
+		case schemeSocketCAN:
+			conn := newInputConn(inputDevice)
+			context.AfterFunc(ctx, func() { conn.Close() })
+			input.conn = conn
+			run(func() { input.CANLoop(ctx, conn) })
+		case schemeSerial:
+			// The serial port is closed after the output has written "no position"
+			inputConn = newInputConn(inputDevice)
+			defer inputConn.Close()
+			input.conn = inputConn
+			run(func() { input.StreamLoop(ctx, inputConn) })
 		}
-		go inputLoop(masterCh, inStatusCh)
+		run(func() { input.Loop(ctx) })
 	}
 
 	// Setup output
-	if cfg.Output.Device == "" {
-		// Output disabled
-	} else if deviceIsUDP(cfg.Output.Device) {
-		// Output to UDP
-		conn, err := net.Dial("udp", cfg.Output.Device)
-		if err != nil {
-			msg := fmt.Sprintf("Error connecting to UDP: %s:%v\n", err, cfg.Output.Device)
-			RunUIError(msg)
-			os.Exit(1)
+	outputter := NewOutputter(ugpsClient, targets)
+	for i, outputDevice := range outputDevices {
+		var writer io.Writer
+		if sameAsInput(outputDevice) {
+			// Output is to same serial port as input
+			writer = inputConn
+		} else {
+			w, err := newOutputWriter(outputDevice)
+			if err != nil {
+				msg := fmt.Sprintf("Error opening output %v: %s\n", outputs[i].Device, err)
+				exitWithError(msg)
+			}
+			defer w.Close()
+			writer = w
 		}
-		defer conn.Close()
-		writer = conn
-
-	} else if !sameInOut {
-		// Output to different serial port
-		port, baudrate := baudAndPortFromDevice(cfg.Output.Device)
+		outputter.addDestination(writer, outputEncoders[i], outputs[i].Rate)
+	}
+	if len(outputter.destinations) > 0 {
+		run(func() { outputter.OutputLoop(ctx) })
+	}
 
-		c := &serial.Mode{BaudRate: baudrate}
-		s, err := serial.Open(port, c)
-		if err != nil {
-			msg := fmt.Sprintf("Error opening serial port %s: %v\n", port, err)
-			RunUIError(msg)
-			os.Exit(1)
-		}
-		defer s.Close()
-		writer = s
+	// The UI expects a status channel even if input is disabled
+	inStatusCh := make(chan inputStats, 1)
+	if input != nil {
+		inStatusCh = input.inputStatusChannel
 	}
 
-	outputter := NewOutputter(writer, serialiser)
-	if writer != nil {
-		go outputter.OutputLoop()
+	if headless {
+		RunHeadless(ctx, cfg, inStatusCh, outputter.outputStatusChannel, cfgSource)
+	} else {
+		RunUI(ctx, cfg, inStatusCh, outputter.outputStatusChannel, cfgSource)
 	}
 
-	RunUI(cfg, inStatusCh, outputter.outputStatusChannel, cfgSource)
+	// Stop all loops and wait for them to finish before closing ports and sockets
+	cancel()
+	wg.Wait()
 }
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NMEA 2000 PGNs sent with the Locator position
+const (
+	pgnWaterDepth          = 128267
+	pgnPositionRapidUpdate = 129025
+	pgnGNSSPositionData    = 129029
+	pgnNavigationData      = 129284
+)
+
+const (
+	// n2kDefaultPriority is the priority of the position PGNs
+	n2kDefaultPriority = 3
+	// n2kSourceAddress is the source address of the messages. The bridge does not claim an address.
+	n2kSourceAddress = 100
+	// n2kBroadcast is the destination address of messages to all devices
+	n2kBroadcast = 255
+	// n2kMaxSingleFrame is the maximum size of a message sent in one CAN frame
+	n2kMaxSingleFrame = 8
+)
+
+// n2kMessage is a NMEA 2000 message
+type n2kMessage struct {
+	pgn         uint32
+	priority    uint8
+	source      uint8
+	destination uint8
+	data        []byte
+}
+
+// canID returns the 29 bit CAN identifier of the message
+func (msg n2kMessage) canID() uint32 {
+	id := uint32(msg.priority&0x7)<<26 | msg.pgn<<8 | uint32(msg.source)
+	if (msg.pgn>>8)&0xFF < 240 {
+		// PDU1 format, the destination is in the PS field
+		id = id&^0xFF00 | uint32(msg.destination)<<8
+	}
+	return id
+}
+
+// frames splits the message into CAN frames. Messages longer than 8 bytes are sent as fast packets
+// where sequence (0-7) identifies the message.
+func (msg n2kMessage) frames(sequence uint8) [][]byte {
+	if len(msg.data) <= n2kMaxSingleFrame {
+		return [][]byte{msg.data}
+	}
+	var frames [][]byte
+	// The first frame has the length and 6 bytes of data, the following frames have 7 bytes of data
+	frame := []byte{sequence << 5, uint8(len(msg.data))}
+	data := msg.data
+	for index := uint8(1); ; index++ {
+		n := min(n2kMaxSingleFrame-len(frame), len(data))
+		frame = append(frame, data[:n]...)
+		data = data[n:]
+		for len(frame) < n2kMaxSingleFrame {
+			frame = append(frame, 0xFF)
+		}
+		frames = append(frames, frame)
+		if len(data) == 0 {
+			return frames
+		}
+		frame = []byte{sequence<<5 | index&0x1F}
+	}
+}
+
+// n2kEncoding encodes a message for writing to a device, each returned packet is written separately
+type n2kEncoding func(msg n2kMessage, sequence uint8) [][]byte
+
+// encodeYDRaw encodes the message as Yacht Devices RAW lines, one line for each CAN frame: 19F80164 01 02 03
+func encodeYDRaw(msg n2kMessage, sequence uint8) [][]byte {
+	var sb strings.Builder
+	for _, frame := range msg.frames(sequence) {
+		fmt.Fprintf(&sb, "%08X", msg.canID())
+		for _, b := range frame {
+			fmt.Fprintf(&sb, " %02X", b)
+		}
+		sb.WriteString("\r\n")
+	}
+	return [][]byte{[]byte(sb.String())}
+}
+
+// Actisense NGT-1 binary protocol
+const (
+	actisenseDLE      = 0x10
+	actisenseSTX      = 0x02
+	actisenseETX      = 0x03
+	actisenseN2KSend  = 0x94
+	actisenseHeadSize = 6
+)
+
+// encodeActisense encodes the message as an Actisense NGT-1 N2K send command.
+// The NGT-1 splits the message into fast packets.
+func encodeActisense(msg n2kMessage, sequence uint8) [][]byte {
+	payload := []byte{actisenseN2KSend, uint8(actisenseHeadSize + len(msg.data)),
+		msg.priority, uint8(msg.pgn), uint8(msg.pgn >> 8), uint8(msg.pgn >> 16), msg.destination, uint8(len(msg.data))}
+	payload = append(payload, msg.data...)
+	// The checksum makes the sum of the command, length, data and checksum 0
+	var sum uint8
+	for _, b := range payload {
+		sum += b
+	}
+	payload = append(payload, -sum)
+
+	packet := []byte{actisenseDLE, actisenseSTX}
+	for _, b := range payload {
+		if b == actisenseDLE {
+			packet = append(packet, actisenseDLE)
+		}
+		packet = append(packet, b)
+	}
+	packet = append(packet, actisenseDLE, actisenseETX)
+	return [][]byte{packet}
+}
+
+// canFrameSize is the size of struct can_frame used by SocketCAN
+const canFrameSize = 16
+
+// canEFFFlag marks a 29 bit identifier in struct can_frame
+const canEFFFlag = 0x80000000
+
+// encodeSocketCAN encodes the message as SocketCAN frames (struct can_frame), written one at the time
+func encodeSocketCAN(msg n2kMessage, sequence uint8) [][]byte {
+	var packets [][]byte
+	for _, frame := range msg.frames(sequence) {
+		packet := make([]byte, canFrameSize)
+		binary.NativeEndian.PutUint32(packet, msg.canID()|canEFFFlag)
+		packet[4] = uint8(len(frame))
+		copy(packet[8:], frame)
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+// n2kEncodings are the supported NMEA 2000 output formats
+var n2kEncodings = map[string]n2kEncoding{
+	"ydraw":     encodeYDRaw,
+	"actisense": encodeActisense,
+}
+
+// Values for data not available
+const (
+	n2kInt16NA  = math.MaxInt16
+	n2kUint16NA = math.MaxUint16
+	n2kInt32NA  = math.MaxInt32
+	n2kUint32NA = math.MaxUint32
+	n2kInt64NA  = math.MaxInt64
+	n2kUint8NA  = math.MaxUint8
+)
+
+// n2kWriter builds the little endian data of a message
+type n2kWriter struct {
+	data []byte
+}
+
+func (w *n2kWriter) uint8(v uint8) {
+	w.data = append(w.data, v)
+}
+
+func (w *n2kWriter) uint16(v uint16) {
+	w.data = binary.LittleEndian.AppendUint16(w.data, v)
+}
+
+func (w *n2kWriter) uint32(v uint32) {
+	w.data = binary.LittleEndian.AppendUint32(w.data, v)
+}
+
+func (w *n2kWriter) uint64(v uint64) {
+	w.data = binary.LittleEndian.AppendUint64(w.data, v)
+}
+
+// scaled returns value/resolution rounded if it is in the range [minimum, notAvailable),
+// otherwise false. NaN is used for values not available.
+func scaled(value, resolution, minimum, notAvailable float64) (float64, bool) {
+	v := math.Round(value / resolution)
+	if math.IsNaN(v) || v < minimum || v >= notAvailable {
+		return 0, false
+	}
+	return v, true
+}
+
+func (w *n2kWriter) scaledInt16(value float64, resolution float64) {
+	if v, ok := scaled(value, resolution, math.MinInt16, n2kInt16NA); ok {
+		w.uint16(uint16(int16(v)))
+		return
+	}
+	w.uint16(n2kInt16NA)
+}
+
+func (w *n2kWriter) scaledUint16(value float64, resolution float64) {
+	if v, ok := scaled(value, resolution, 0, n2kUint16NA); ok {
+		w.uint16(uint16(v))
+		return
+	}
+	w.uint16(n2kUint16NA)
+}
+
+func (w *n2kWriter) scaledInt32(value float64, resolution float64) {
+	if v, ok := scaled(value, resolution, math.MinInt32, n2kInt32NA); ok {
+		w.uint32(uint32(int32(v)))
+		return
+	}
+	w.uint32(n2kInt32NA)
+}
+
+func (w *n2kWriter) scaledUint32(value float64, resolution float64) {
+	if v, ok := scaled(value, resolution, 0, n2kUint32NA); ok {
+		w.uint32(uint32(v))
+		return
+	}
+	w.uint32(n2kUint32NA)
+}
+
+func (w *n2kWriter) scaledInt64(value float64, resolution float64) {
+	if v, ok := scaled(value, resolution, math.MinInt64, n2kInt64NA); ok {
+		w.uint64(uint64(int64(v)))
+		return
+	}
+	w.uint64(n2kInt64NA)
+}
+
+// n2kPGNSerialiser encodes a PGN with the Locator position.
+// sid is the sequence identifier linking PGNs from the same position.
+type n2kPGNSerialiser interface {
+	serialise(fix locatorFix, sid uint8) n2kMessage
+	noPosition(sid uint8) n2kMessage
+}
+
+// n2kPGNs writes a NMEA 2000 message from each serialiser
+type n2kPGNs struct {
+	serialisers []n2kPGNSerialiser
+	encoding    n2kEncoding
+	sid         uint8
+	// sequences are the fast packet sequence counters for each PGN
+	sequences map[uint32]uint8
+}
+
+func newN2KPGNs(serialisers []n2kPGNSerialiser, encoding n2kEncoding) *n2kPGNs {
+	return &n2kPGNs{serialisers: serialisers, encoding: encoding, sequences: make(map[uint32]uint8)}
+}
+
+func (p *n2kPGNs) write(w io.Writer, msg n2kMessage) error {
+	sequence := p.sequences[msg.pgn]
+	p.sequences[msg.pgn] = (sequence + 1) & 0x7
+	for _, packet := range p.encoding(msg, sequence) {
+		if _, err := w.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextSID returns the sequence identifier for the next position, 0-252
+func (p *n2kPGNs) nextSID() uint8 {
+	sid := p.sid
+	p.sid = (p.sid + 1) % 253
+	return sid
+}
+
+func (p *n2kPGNs) writePosition(w io.Writer, fix locatorFix) error {
+	sid := p.nextSID()
+	for _, serialiser := range p.serialisers {
+		if err := p.write(w, serialiser.serialise(fix, sid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *n2kPGNs) usesTopside() bool {
+	return slices.ContainsFunc(p.serialisers, func(serialiser n2kPGNSerialiser) bool { return usesTopside(serialiser) })
+}
+
+func (p *n2kPGNs) writeNoPosition(w io.Writer) error {
+	sid := p.nextSID()
+	for _, serialiser := range p.serialisers {
+		if err := p.write(w, serialiser.noPosition(sid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newN2KMessage(pgn uint32, w n2kWriter) n2kMessage {
+	return n2kMessage{pgn: pgn, priority: n2kDefaultPriority, source: n2kSourceAddress, destination: n2kBroadcast, data: w.data}
+}
+
+// n2kRapidPosition is PGN 129025 Position, Rapid Update
+type n2kRapidPosition struct{}
+
+func (n2kRapidPosition) serialise(fix locatorFix, sid uint8) n2kMessage {
+	var w n2kWriter
+	w.scaledInt32(fix.global.Latitude, 1e-7)
+	w.scaledInt32(fix.global.Longitude, 1e-7)
+	return newN2KMessage(pgnPositionRapidUpdate, w)
+}
+
+func (n2kRapidPosition) noPosition(sid uint8) n2kMessage {
+	var w n2kWriter
+	w.uint32(n2kInt32NA)
+	w.uint32(n2kInt32NA)
+	return newN2KMessage(pgnPositionRapidUpdate, w)
+}
+
+// n2kGNSSPosition is PGN 129029 GNSS Position Data
+type n2kGNSSPosition struct{}
+
+func (n2kGNSSPosition) serialise(fix locatorFix, sid uint8) n2kMessage {
+	return gnssPositionData(sid, time.Now().UTC(), fix.global.Latitude, fix.global.Longitude, -fix.acoustic.Z,
+		fix.global.FixQuality, fix.global.NumSats, fix.global.Hdop)
+}
+
+func (n2kGNSSPosition) noPosition(sid uint8) n2kMessage {
+	return gnssPositionData(sid, time.Now().UTC(), math.NaN(), math.NaN(), math.NaN(), QualityNoFix, 0, 0)
+}
+
+// gnssPositionData encodes PGN 129029. The method is the same as the GGA quality indicator.
+// NaN values are sent as not available.
+func gnssPositionData(sid uint8, now time.Time, lat, lon, altitude, method, numSats, hdop float64) n2kMessage {
+	var w n2kWriter
+	w.uint8(sid)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	w.uint16(uint16(midnight.Unix() / 86400))
+	w.scaledUint32(now.Sub(midnight).Seconds(), 1e-4)
+	w.scaledInt64(lat, 1e-16)
+	w.scaledInt64(lon, 1e-16)
+	w.scaledInt64(altitude, 1e-6)
+	// GNSS type GPS and method
+	w.uint8(uint8(method) << 4)
+	// Integrity no checking, 6 reserved bits
+	w.uint8(0xFC)
+	w.uint8(uint8(numSats))
+	if hdop > 0 {
+		w.scaledInt16(hdop, 0.01)
+	} else {
+		w.uint16(n2kInt16NA)
+	}
+	// PDOP and geoidal separation not available, no reference stations
+	w.uint16(n2kInt16NA)
+	w.uint32(n2kInt32NA)
+	w.uint8(0)
+	return newN2KMessage(pgnGNSSPositionData, w)
+}
+
+// n2kWaterDepth is PGN 128267 Water Depth with the depth of the Locator
+type n2kWaterDepth struct{}
+
+func (n2kWaterDepth) serialise(fix locatorFix, sid uint8) n2kMessage {
+	var w n2kWriter
+	w.uint8(sid)
+	w.scaledUint32(fix.acoustic.Z, 0.01)
+	// No offset, range not available
+	w.scaledInt16(0, 0.001)
+	w.uint8(n2kUint8NA)
+	return newN2KMessage(pgnWaterDepth, w)
+}
+
+func (n2kWaterDepth) noPosition(sid uint8) n2kMessage {
+	var w n2kWriter
+	w.uint8(sid)
+	w.uint32(n2kUint32NA)
+	w.uint16(n2kInt16NA)
+	w.uint8(n2kUint8NA)
+	return newN2KMessage(pgnWaterDepth, w)
+}
+
+// n2kNavigationData is PGN 129284 Navigation Data with the Locator as the destination
+// and the distance and bearing from the topside
+type n2kNavigationData struct{}
+
+func (n2kNavigationData) serialise(fix locatorFix, sid uint8) n2kMessage {
+	if fix.master == nil {
+		return n2kNavigationData{}.noPosition(sid)
+	}
+	distance, bearing := locatorRangeBearing(fix)
+	return navigationData(sid, distance, bearing, fix.global.Latitude, fix.global.Longitude)
+}
+
+func (n2kNavigationData) usesTopside() bool {
+	return true
+}
+
+func (n2kNavigationData) noPosition(sid uint8) n2kMessage {
+	return navigationData(sid, math.NaN(), math.NaN(), math.NaN(), math.NaN())
+}
+
+// navigationData encodes PGN 129284. NaN values are sent as not available.
+func navigationData(sid uint8, distance, bearing, lat, lon float64) n2kMessage {
+	var w n2kWriter
+	w.uint8(sid)
+	w.scaledUint32(distance, 0.01)
+	// Bearing reference true, perpendicular and arrival circle not crossed, great circle
+	w.uint8(0)
+	// ETA time and date not available
+	w.uint32(n2kUint32NA)
+	w.uint16(n2kUint16NA)
+	// Bearing from origin not available, bearing from position to destination in radians
+	w.uint16(n2kUint16NA)
+	w.scaledUint16(bearing*math.Pi/180, 1e-4)
+	// Origin waypoint not available, destination waypoint 1
+	w.uint32(n2kUint32NA)
+	w.uint32(1)
+	w.scaledInt32(lat, 1e-7)
+	w.scaledInt32(lon, 1e-7)
+	// Waypoint closing velocity not available
+	w.uint16(n2kInt16NA)
+	return newN2KMessage(pgnNavigationData, w)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/waterlinked/ugps-go/ugps"
+)
+
+func TestN2KCanID(t *testing.T) {
+	msg := n2kMessage{pgn: pgnPositionRapidUpdate, priority: 2, source: 100, destination: n2kBroadcast}
+	assert.Equal(t, uint32(0x09F80164), msg.canID())
+
+	// PDU1 PGNs have the destination in the identifier, ISO Request to address 0x23
+	msg = n2kMessage{pgn: 59904, priority: 6, source: 100, destination: 0x23}
+	assert.Equal(t, uint32(0x18EA2364), msg.canID())
+}
+
+func TestN2KFastPacket(t *testing.T) {
+	data := make([]byte, 20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	msg := n2kMessage{pgn: pgnGNSSPositionData, data: data}
+	frames := msg.frames(5)
+	require.Len(t, frames, 3)
+	assert.Equal(t, []byte{0xA0, 20, 0, 1, 2, 3, 4, 5}, frames[0])
+	assert.Equal(t, []byte{0xA1, 6, 7, 8, 9, 10, 11, 12}, frames[1])
+	assert.Equal(t, []byte{0xA2, 13, 14, 15, 16, 17, 18, 19}, frames[2])
+
+	msg.data = data[:14]
+	frames = msg.frames(0)
+	require.Len(t, frames, 3)
+	assert.Equal(t, []byte{0x02, 13, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, frames[2])
+
+	msg.data = data[:8]
+	assert.Equal(t, [][]byte{data[:8]}, msg.frames(0))
+}
+
+func TestN2KEncodings(t *testing.T) {
+	msg := n2kMessage{pgn: pgnPositionRapidUpdate, priority: 2, source: 100, destination: n2kBroadcast,
+		data: []byte{0x10, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}}
+
+	assert.Equal(t, [][]byte{[]byte("09F80164 10 02 03 04 05 06 07 08\r\n")}, encodeYDRaw(msg, 0))
+
+	// DLE in the data is escaped
+	packets := encodeActisense(msg, 0)
+	require.Len(t, packets, 1)
+	packet := packets[0]
+	assert.Equal(t, []byte{0x10, 0x02, 0x94, 14, 2, 0x01, 0xF8, 0x01, 0xFF, 8, 0x10, 0x10, 0x02}, packet[:13])
+	assert.Equal(t, []byte{0x10, 0x03}, packet[len(packet)-2:])
+	var sum uint8
+	for _, b := range bytes.ReplaceAll(packet[2:len(packet)-2], []byte{0x10, 0x10}, []byte{0x10}) {
+		sum += b
+	}
+	assert.Equal(t, uint8(0), sum)
+
+	packets = encodeSocketCAN(msg, 0)
+	require.Len(t, packets, 1)
+	assert.Equal(t, uint32(0x89F80164), binary.NativeEndian.Uint32(packets[0]))
+	assert.Equal(t, uint8(8), packets[0][4])
+	assert.Equal(t, msg.data, packets[0][8:])
+}
+
+func testFix() locatorFix {
+	return locatorFix{
+		global:   ugps.GlobalPosition{Latitude: 63.4, Longitude: -10.4, FixQuality: 2, NumSats: 9, Hdop: 0.8},
+		acoustic: ugps.AcousticPosition{X: 30, Y: -40, Z: 12.5},
+		master:   &ugps.GlobalPosition{Latitude: 63.4, Longitude: -10.4, Orientation: 90},
+	}
+}
+
+func TestN2KRapidPosition(t *testing.T) {
+	msg := n2kRapidPosition{}.serialise(testFix(), 0)
+	assert.Equal(t, uint32(pgnPositionRapidUpdate), msg.pgn)
+	require.Len(t, msg.data, 8)
+	assert.Equal(t, int32(634000000), int32(binary.LittleEndian.Uint32(msg.data)))
+	assert.Equal(t, int32(-104000000), int32(binary.LittleEndian.Uint32(msg.data[4:])))
+
+	msg = n2kRapidPosition{}.noPosition(0)
+	assert.Equal(t, []byte{0xFF, 0xFF, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 0x7F}, msg.data)
+}
+
+func TestN2KGNSSPosition(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 15, 500000000, time.UTC)
+	msg := gnssPositionData(7, now, 63.4, -10.4, -12.5, 2, 9, 0.8)
+	assert.Equal(t, uint32(pgnGNSSPositionData), msg.pgn)
+	data := msg.data
+	require.Len(t, data, 43)
+	assert.Equal(t, uint8(7), data[0])
+	assert.Equal(t, uint16(19844), binary.LittleEndian.Uint16(data[1:]))
+	assert.Equal(t, uint32(450155000), binary.LittleEndian.Uint32(data[3:]))
+	assert.InDelta(t, 63.4, float64(int64(binary.LittleEndian.Uint64(data[7:])))*1e-16, 1e-9)
+	assert.InDelta(t, -10.4, float64(int64(binary.LittleEndian.Uint64(data[15:])))*1e-16, 1e-9)
+	assert.Equal(t, int64(-12500000), int64(binary.LittleEndian.Uint64(data[23:])))
+	// GPS, DGNSS fix
+	assert.Equal(t, uint8(0x20), data[31])
+	assert.Equal(t, uint8(9), data[33])
+	assert.Equal(t, int16(80), int16(binary.LittleEndian.Uint16(data[34:])))
+	assert.Equal(t, uint8(0), data[42])
+
+	msg = n2kGNSSPosition{}.noPosition(0)
+	require.Len(t, msg.data, 43)
+	assert.Equal(t, uint64(math.MaxInt64), binary.LittleEndian.Uint64(msg.data[7:]))
+	assert.Equal(t, uint8(0), msg.data[31])
+}
+
+func TestN2KWaterDepth(t *testing.T) {
+	msg := n2kWaterDepth{}.serialise(testFix(), 3)
+	assert.Equal(t, uint32(pgnWaterDepth), msg.pgn)
+	assert.Equal(t, []byte{3, 0xE2, 0x04, 0, 0, 0, 0, 0xFF}, msg.data)
+}
+
+func TestN2KNavigationData(t *testing.T) {
+	msg := n2kNavigationData{}.serialise(testFix(), 0)
+	assert.Equal(t, uint32(pgnNavigationData), msg.pgn)
+	data := msg.data
+	require.Len(t, data, 34)
+	assert.Equal(t, uint32(5000), binary.LittleEndian.Uint32(data[1:]))
+	assert.InDelta(t, (90-53.13)*math.Pi/180, float64(binary.LittleEndian.Uint16(data[14:]))*1e-4, 1e-3)
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(data[20:]))
+	assert.Equal(t, int32(634000000), int32(binary.LittleEndian.Uint32(data[24:])))
+
+	msg = n2kNavigationData{}.noPosition(0)
+	assert.Equal(t, uint32(math.MaxUint32), binary.LittleEndian.Uint32(msg.data[1:]))
+}
+
+func TestN2KPGNs(t *testing.T) {
+	var buf bytes.Buffer
+	pgns := newN2KPGNs([]n2kPGNSerialiser{n2kRapidPosition{}, n2kGNSSPosition{}}, encodeYDRaw)
+	require.NoError(t, pgns.writePosition(&buf, testFix()))
+	require.NoError(t, pgns.writePosition(&buf, testFix()))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\r\n"))
+	// One frame for 129025 and 7 for 129029, twice
+	require.Len(t, lines, 16)
+	assert.True(t, bytes.HasPrefix(lines[0], []byte("0DF80164 ")))
+	assert.True(t, bytes.HasPrefix(lines[1], []byte("0DF80564 00 2B 00 ")))
+	// The next position has a new SID and fast packet sequence
+	assert.True(t, bytes.HasPrefix(lines[9], []byte("0DF80564 20 2B 01 ")))
+}
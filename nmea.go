@@ -5,6 +5,9 @@ import (
 	"math"
 	"strings"
 	"time"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/waterlinked/ugps-go/ugps"
 )
 
 // PrependZero prepends zeros until the given number of decimals
@@ -93,9 +96,39 @@ func (l Lng) CardinalPoint() string {
 	return "E"
 }
 
+// talkerID returns talker if set, otherwise the default talker ID of the sentence
+func talkerID(talker string, defaultTalker string) string {
+	if talker == "" {
+		return defaultTalker
+	}
+	return talker
+}
+
+// validTalker returns true if talker is a two character NMEA talker ID, eg. GP, GN or II
+func validTalker(talker string) bool {
+	if len(talker) != 2 {
+		return false
+	}
+	for _, c := range talker {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
 func assembleSentence(fields []string) string {
+	return assemble("$", fields)
+}
+
+// assembleEncapsulationSentence assembles a sentence with encapsulated data, eg. AIS VDM, which starts with !
+func assembleEncapsulationSentence(fields []string) string {
+	return assemble("!", fields)
+}
+
+func assemble(start string, fields []string) string {
 	sentence := strings.Join(fields, ",")
-	out := "$" + sentence + "*"
+	out := start + sentence + "*"
 	var csum uint8
 	for i := 0; i < len(sentence); i++ {
 		csum ^= sentence[i]
@@ -123,6 +156,8 @@ Field Number:
 9. R= reference target; null (,,)= otherwise
 */
 type RATLL struct {
+	// Talker is the talker ID, RA if empty
+	Talker       string
 	TargetNum    int
 	Latitude     Lat // In decimal format
 	Longitude    Lng // In decimal format
@@ -138,7 +173,7 @@ func (sentence RATLL) Serialise() string {
 func (sentence RATLL) SerialiseDecimals(decimals uint) string {
 
 	fields := make([]string, 0)
-	fields = append(fields, "RATLL")
+	fields = append(fields, talkerID(sentence.Talker, "RA")+"TLL")
 
 	fields = append(fields, fmt.Sprintf("%d", sentence.TargetNum))
 
@@ -159,6 +194,73 @@ func (sentence RATLL) SerialiseDecimals(decimals uint) string {
 	return assembleSentence(fields)
 }
 
+// metersPerNauticalMile is the length of a nautical mile
+const metersPerNauticalMile = 1852
+
+/*
+RATTM struct represents the "--TTM" NMEA sentence
+
+https://gpsd.gitlab.io/gpsd/NMEA.html#_ttm_tracked_target_message
+
+Field Number:
+
+1. Target Number (0-99)
+2. Target Distance
+3. Bearing from own ship
+4. T = True, R = Relative
+5. Target Speed
+6. Target Course
+7. T = True, R = Relative
+8. Distance of closest-point-of-approach
+9. Time until closest-point-of-approach, "-" means increasing
+10. Speed/distance units, K/N/S
+11. Target name
+12. Target Status (L=lost, Q=acquisition, T=tracking)
+13. R= reference target; null (,,)= otherwise
+14. UTC of data
+15. Type of acquisition, A = Auto, M = Manual, R = Reported
+
+Example: $RATTM,11,11.4,13.6,T,7.0,20.0,T,0.2,-1.0,N,TGT11,T,,104512.00,A*12
+*/
+type RATTM struct {
+	// Talker is the talker ID, RA if empty
+	Talker    string
+	TargetNum int
+	// Distance from own ship in nautical miles
+	Distance float64
+	// Bearing from own ship in degrees true
+	Bearing float64
+	// Sog is speed over ground in knots
+	Sog float64
+	// Cog is course over ground in degrees true
+	Cog          float64
+	TargetName   string
+	TimeUTC      time.Time
+	TargetStatus byte // L=lost, Q=acuisition, T=tracking
+}
+
+func (sentence RATTM) Serialise() string {
+	fields := []string{talkerID(sentence.Talker, "RA") + "TTM", fmt.Sprintf("%02d", sentence.TargetNum)}
+	if sentence.TargetStatus == 'T' {
+		fields = append(fields,
+			fmt.Sprintf("%.4f", sentence.Distance), fmt.Sprintf("%.1f", sentence.Bearing), "T",
+			fmt.Sprintf("%.2f", sentence.Sog), fmt.Sprintf("%.1f", sentence.Cog), "T",
+		)
+	} else {
+		fields = append(fields, "", "", "T", "", "", "T")
+	}
+	// The closest point of approach is not computed
+	fields = append(fields, "", "", "N", sentence.TargetName)
+	if sentence.TargetStatus == 'T' {
+		fields = append(fields, "T")
+	} else {
+		fields = append(fields, "L")
+	}
+	fields = append(fields, "", sentence.TimeUTC.Format("150405.000"), "A")
+
+	return assembleSentence(fields)
+}
+
 /*
 GAGGA structure represents --GGA message
 https://gpsd.gitlab.io/gpsd/NMEA.html#_gga_global_positioning_system_fix_data
@@ -194,6 +296,8 @@ Example: $GPGGA,015540.000,3150.68378,N,11711.93139,E,1,17,0.6,0051.6,M,0.0,M,,*
 */
 
 type GAGGA struct {
+	// Talker is the talker ID, GP if empty
+	Talker                 string
 	TimeUTC                time.Time
 	Latitude               Lat
 	Longitude              Lng
@@ -210,7 +314,7 @@ func (sentence GAGGA) Serialise() string {
 func (sentence GAGGA) SerialiseDecimals(decimals uint) string {
 
 	fields := make([]string, 0)
-	fields = append(fields, "GPGGA")
+	fields = append(fields, talkerID(sentence.Talker, "GP")+"GGA")
 
 	fields = append(fields, sentence.TimeUTC.Format("150405.000"))
 
@@ -232,3 +336,561 @@ func (sentence GAGGA) SerialiseDecimals(decimals uint) string {
 
 	return assembleSentence(fields)
 }
+
+// faaMode returns the mode indicator for the GGA quality indicator
+func faaMode(quality float64) string {
+	switch quality {
+	case 0:
+		return "N"
+	case 2:
+		return "D"
+	case 4:
+		return "R"
+	case 5:
+		return "F"
+	case 6:
+		return "E"
+	case 7:
+		return "M"
+	case 8:
+		return "S"
+	}
+	return "A"
+}
+
+/*
+GPGLL structure represents --GLL message
+https://gpsd.gitlab.io/gpsd/NMEA.html#_gll_geographic_position_latitudelongitude
+
+Fields:
+1. Latitude
+2. N or S (North or South)
+3. Longitude
+4. E or W (East or West)
+5. UTC of this position
+6. Status A - Data Valid, V - Data Invalid
+7. FAA mode indicator
+8. Checksum
+
+Example: $GPGLL,4916.45,N,12311.12,W,225444,A,A*5C
+*/
+type GPGLL struct {
+	// Talker is the talker ID, GP if empty
+	Talker           string
+	TimeUTC          time.Time
+	Latitude         Lat
+	Longitude        Lng
+	QualityIndicator float64
+}
+
+func (sentence GPGLL) Serialise() string {
+	return sentence.SerialiseDecimals(6)
+}
+
+func (sentence GPGLL) SerialiseDecimals(decimals uint) string {
+	fields := []string{talkerID(sentence.Talker, "GP") + "GLL",
+		sentence.Latitude.Serialise(decimals), sentence.Latitude.CardinalPoint(),
+		sentence.Longitude.Serialise(decimals), sentence.Longitude.CardinalPoint(),
+		sentence.TimeUTC.Format("150405.000"),
+	}
+	if sentence.QualityIndicator > 0 {
+		fields = append(fields, "A")
+	} else {
+		fields = append(fields, "V")
+	}
+	fields = append(fields, faaMode(sentence.QualityIndicator))
+
+	return assembleSentence(fields)
+}
+
+/*
+GPRMC structure represents --RMC message
+https://gpsd.gitlab.io/gpsd/NMEA.html#_rmc_recommended_minimum_navigation_information
+
+Fields:
+1. UTC of position fix
+2. Status A - Data Valid, V - Data Invalid
+3. Latitude
+4. N or S (North or South)
+5. Longitude
+6. E or W (East or West)
+7. Speed over ground, knots
+8. Track made good, degrees true
+9. Date, ddmmyy
+10. Magnetic Variation, degrees
+11. E or W (East or West)
+12. FAA mode indicator
+13. Checksum
+
+Example: $GPRMC,225446,A,4916.45,N,12311.12,W,000.5,054.7,191194,020.3,E*68
+*/
+type GPRMC struct {
+	// Talker is the talker ID, GP if empty
+	Talker           string
+	TimeUTC          time.Time
+	Latitude         Lat
+	Longitude        Lng
+	QualityIndicator float64
+	// Sog is speed over ground in knots
+	Sog float64
+	// Cog is course over ground in degrees true
+	Cog float64
+}
+
+func (sentence GPRMC) Serialise() string {
+	return sentence.SerialiseDecimals(6)
+}
+
+func (sentence GPRMC) SerialiseDecimals(decimals uint) string {
+	fields := []string{talkerID(sentence.Talker, "GP") + "RMC", sentence.TimeUTC.Format("150405.000")}
+	if sentence.QualityIndicator > 0 {
+		fields = append(fields, "A")
+	} else {
+		fields = append(fields, "V")
+	}
+	fields = append(fields,
+		sentence.Latitude.Serialise(decimals), sentence.Latitude.CardinalPoint(),
+		sentence.Longitude.Serialise(decimals), sentence.Longitude.CardinalPoint(),
+	)
+	if sentence.QualityIndicator > 0 {
+		fields = append(fields, fmt.Sprintf("%.2f", sentence.Sog), fmt.Sprintf("%.1f", sentence.Cog))
+	} else {
+		fields = append(fields, "", "")
+	}
+	fields = append(fields, sentence.TimeUTC.Format("020106"), "", "")
+	fields = append(fields, faaMode(sentence.QualityIndicator))
+
+	return assembleSentence(fields)
+}
+
+/*
+GPGNS structure represents --GNS message
+https://gpsd.gitlab.io/gpsd/NMEA.html#_gns_fix_data
+
+Fields:
+1. UTC of position fix
+2. Latitude
+3. N or S (North or South)
+4. Longitude
+5. E or W (East or West)
+6. Mode indicator, one character for each constellation (only GPS is used)
+7. Total number of satellites in use, 00-99
+8. HDOP
+9. Antenna altitude, meters
+10. Geoidal separation, meters
+11. Age of differential data
+12. Differential reference station ID
+13. Checksum
+
+Example: $GPGNS,224749.00,3333.4268304,N,11153.3538273,W,D,19,0.6,406.110,-26.294,6.0,0138*20
+*/
+type GPGNS struct {
+	// Talker is the talker ID, GP if empty
+	Talker                 string
+	TimeUTC                time.Time
+	Latitude               Lat
+	Longitude              Lng
+	QualityIndicator       float64
+	NumberOfSatellitesUsed int
+	Altitude               float64
+	Hdop                   float64
+}
+
+func (sentence GPGNS) Serialise() string {
+	return sentence.SerialiseDecimals(6)
+}
+
+func (sentence GPGNS) SerialiseDecimals(decimals uint) string {
+	fields := []string{talkerID(sentence.Talker, "GP") + "GNS", sentence.TimeUTC.Format("150405.000"),
+		sentence.Latitude.Serialise(decimals), sentence.Latitude.CardinalPoint(),
+		sentence.Longitude.Serialise(decimals), sentence.Longitude.CardinalPoint(),
+		faaMode(sentence.QualityIndicator),
+		fmt.Sprintf("%02d", sentence.NumberOfSatellitesUsed),
+	}
+	if sentence.Hdop > 0 {
+		fields = append(fields, fmt.Sprintf("%.1f", sentence.Hdop))
+	} else {
+		fields = append(fields, "")
+	}
+	fields = append(fields, fmt.Sprintf("%.2f", sentence.Altitude), "", "", "")
+
+	return assembleSentence(fields)
+}
+
+/*
+GPVTG structure represents --VTG message
+https://gpsd.gitlab.io/gpsd/NMEA.html#_vtg_track_made_good_and_ground_speed
+
+Fields:
+1. Course over ground, degrees true
+2. T = True
+3. Course over ground, degrees magnetic
+4. M = Magnetic
+5. Speed over ground, knots
+6. N = Knots
+7. Speed over ground, km/h
+8. K = Kilometers per hour
+9. FAA mode indicator
+10. Checksum
+
+Example: $GPVTG,220.86,T,,M,2.550,N,4.724,K,A*34
+*/
+type GPVTG struct {
+	// Talker is the talker ID, GP if empty
+	Talker           string
+	QualityIndicator float64
+	// Sog is speed over ground in knots
+	Sog float64
+	// Cog is course over ground in degrees true
+	Cog float64
+}
+
+func (sentence GPVTG) Serialise() string {
+	fields := []string{talkerID(sentence.Talker, "GP") + "VTG"}
+	if sentence.QualityIndicator > 0 {
+		fields = append(fields,
+			fmt.Sprintf("%.1f", sentence.Cog), "T", "", "M",
+			fmt.Sprintf("%.2f", sentence.Sog), "N", fmt.Sprintf("%.2f", sentence.Sog*kphPerKnot), "K",
+		)
+	} else {
+		fields = append(fields, "", "T", "", "M", "", "N", "", "K")
+	}
+	fields = append(fields, faaMode(sentence.QualityIndicator))
+
+	return assembleSentence(fields)
+}
+
+/*
+SDDPT structure represents --DPT message with the depth of the Locator below the surface
+https://gpsd.gitlab.io/gpsd/NMEA.html#_dpt_depth_of_water
+
+Fields:
+1. Depth, meters
+2. Offset from transducer, meters
+3. Maximum range scale in use
+4. Checksum
+
+Example: $SDDPT,12.50,0.0,*7D
+*/
+type SDDPT struct {
+	// Talker is the talker ID, SD if empty
+	Talker string
+	// Valid is false if the depth is not available
+	Valid bool
+	// Depth in meters
+	Depth float64
+}
+
+func (sentence SDDPT) Serialise() string {
+	fields := []string{talkerID(sentence.Talker, "SD") + "DPT"}
+	if sentence.Valid {
+		fields = append(fields, fmt.Sprintf("%.2f", sentence.Depth), "0.0", "")
+	} else {
+		fields = append(fields, "", "", "")
+	}
+	return assembleSentence(fields)
+}
+
+/*
+SDDBT structure represents --DBT message with the depth of the Locator below the surface
+https://gpsd.gitlab.io/gpsd/NMEA.html#_dbt_depth_below_transducer
+
+Fields:
+1. Depth, feet
+2. f = feet
+3. Depth, meters
+4. M = meters
+5. Depth, fathoms
+6. F = fathoms
+7. Checksum
+
+Example: $SDDBT,41.01,f,12.50,M,6.84,F*3E
+*/
+type SDDBT struct {
+	// Talker is the talker ID, SD if empty
+	Talker string
+	// Valid is false if the depth is not available
+	Valid bool
+	// Depth in meters
+	Depth float64
+}
+
+func (sentence SDDBT) Serialise() string {
+	fields := []string{talkerID(sentence.Talker, "SD") + "DBT"}
+	if sentence.Valid {
+		fields = append(fields,
+			fmt.Sprintf("%.2f", sentence.Depth/metersPerFoot), "f",
+			fmt.Sprintf("%.2f", sentence.Depth), "M",
+			fmt.Sprintf("%.2f", sentence.Depth/metersPerFathom), "F",
+		)
+	} else {
+		fields = append(fields, "", "f", "", "M", "", "F")
+	}
+	return assembleSentence(fields)
+}
+
+/*
+YXXDR structure represents --XDR message with a depth measurement
+https://gpsd.gitlab.io/gpsd/NMEA.html#_xdr_transducer_measurement
+
+Fields:
+1. Transducer type, D = depth
+2. Measurement
+3. Units, M = meters
+4. Transducer name
+5. Checksum
+
+Example: $YXXDR,D,12.50,M,LOCATOR*26
+*/
+type YXXDR struct {
+	// Talker is the talker ID, YX if empty
+	Talker string
+	// Valid is false if the depth is not available
+	Valid bool
+	// Depth in meters
+	Depth float64
+	// Name is the transducer name
+	Name string
+}
+
+func (sentence YXXDR) Serialise() string {
+	depth := ""
+	if sentence.Valid {
+		depth = fmt.Sprintf("%.2f", sentence.Depth)
+	}
+	return assembleSentence([]string{talkerID(sentence.Talker, "YX") + "XDR", "D", depth, "M", sentence.Name})
+}
+
+/*
+PWLDEP structure represents the proprietary Water Linked $PWLDEP message with the depth of the Locator
+below the surface. Proprietary sentences have no talker ID.
+
+Fields:
+1. UTC of data
+2. Depth, meters
+3. M = meters
+4. Status A - Data Valid, V - Data Invalid (no position from the Underwater GPS)
+5. Checksum
+
+Example: $PWLDEP,203458.651,12.50,M,A*2A
+*/
+type PWLDEP struct {
+	TimeUTC time.Time
+	// Valid is false if the depth is not available
+	Valid bool
+	// Depth in meters
+	Depth float64
+}
+
+func (sentence PWLDEP) Serialise() string {
+	fields := []string{"PWLDEP", sentence.TimeUTC.Format("150405.000")}
+	if sentence.Valid {
+		fields = append(fields, fmt.Sprintf("%.2f", sentence.Depth), "M", "A")
+	} else {
+		fields = append(fields, "", "M", "V")
+	}
+	return assembleSentence(fields)
+}
+
+/*
+PWLPOS structure represents the proprietary Water Linked $PWLPOS message with the full solution from the
+Underwater GPS: the global position of the Locator and the acoustic position relative to the topside antenna.
+Proprietary sentences have no talker ID. The sentence is longer than the 82 characters allowed by NMEA 0183.
+
+Fields:
+1. UTC of data
+2. Latitude, ddmm.mmmmmm
+3. N or S (North or South)
+4. Longitude, dddmm.mmmmmm
+5. E or W (East or West)
+6. GPS quality indicator
+7. Number of satellites in use
+8. Horizontal dilution of precision
+9. Course over ground, degrees true
+10. Speed over ground, knots
+11. Orientation of the topside, degrees true
+12. X (forward) of the Locator relative to the topside antenna, meters
+13. Y (starboard), meters
+14. Z (depth), meters
+15. Standard deviation of the acoustic position, meters
+16. Status A - Acoustic position valid, V - Acoustic position invalid
+17. Number of receivers n
+18. Followed by n groups of receiver distance (meters), noise spectral density, RSSI and status A/V
+19. Checksum
+
+Example: $PWLPOS,203458.651,6324.000000,N,01024.000000,W,2,12,0.7,123.4,1.50,45.0,10.50,-3.25,12.50,0.35,A,2,14.02,-50.1,-20.3,A,14.11,-49.8,-22.0,V*6E
+*/
+type PWLPOS struct {
+	TimeUTC time.Time
+	// Valid is false if there is no position from the Underwater GPS, the data fields are then empty
+	Valid    bool
+	Global   ugps.GlobalPosition
+	Acoustic ugps.AcousticPosition
+}
+
+// pwlStatus returns A for valid and V for invalid
+func pwlStatus(valid bool) string {
+	if valid {
+		return "A"
+	}
+	return "V"
+}
+
+func (sentence PWLPOS) Serialise() string {
+	fields := []string{"PWLPOS", sentence.TimeUTC.Format("150405.000")}
+	if !sentence.Valid {
+		fields = append(fields, "", "", "", "", "", "", "", "", "", "", "", "", "", "", "V", "0")
+		return assembleSentence(fields)
+	}
+	global, acoustic := sentence.Global, sentence.Acoustic
+	fields = append(fields,
+		Lat(global.Latitude).Serialise(6), Lat(global.Latitude).CardinalPoint(),
+		Lng(global.Longitude).Serialise(6), Lng(global.Longitude).CardinalPoint(),
+		fmt.Sprintf("%.0f", global.FixQuality),
+		fmt.Sprintf("%.0f", global.NumSats),
+		fmt.Sprintf("%.1f", global.Hdop),
+		fmt.Sprintf("%.1f", global.Cog),
+		fmt.Sprintf("%.2f", global.Sog),
+		fmt.Sprintf("%.1f", global.Orientation),
+		fmt.Sprintf("%.2f", acoustic.X),
+		fmt.Sprintf("%.2f", acoustic.Y),
+		fmt.Sprintf("%.2f", acoustic.Z),
+		fmt.Sprintf("%.2f", acoustic.Std),
+		pwlStatus(acoustic.PositionValid),
+	)
+	// The receiver arrays have the same length, but use the shortest in case they do not
+	receivers := min(len(acoustic.ReceiverDistance), len(acoustic.ReceiverNsd), len(acoustic.ReceiverRssi), len(acoustic.ReceiverValid))
+	fields = append(fields, fmt.Sprintf("%d", receivers))
+	for i := 0; i < receivers; i++ {
+		fields = append(fields,
+			fmt.Sprintf("%.2f", acoustic.ReceiverDistance[i]),
+			fmt.Sprintf("%.1f", acoustic.ReceiverNsd[i]),
+			fmt.Sprintf("%.1f", acoustic.ReceiverRssi[i]),
+			pwlStatus(acoustic.ReceiverValid[i]),
+		)
+	}
+	return assembleSentence(fields)
+}
+
+const (
+	// TypePWLPOS is the type of the parsed PWLPOS sentence, the P prefix is the talker of proprietary sentences
+	TypePWLPOS = "WLPOS"
+	// pwlPosFields is the number of fields in PWLPOS before the receivers
+	pwlPosFields = 17
+	// pwlPosReceiverFields is the number of fields for each receiver in PWLPOS
+	pwlPosReceiverFields = 4
+)
+
+// PWLPOSSentence is a parsed PWLPOS sentence. TimeUTC only has the time of day.
+type PWLPOSSentence struct {
+	nmea.BaseSentence
+	PWLPOS
+}
+
+func init() {
+	nmea.MustRegisterParser(TypePWLPOS, newPWLPOS)
+}
+
+// newPWLPOS parses a PWLPOS sentence, it is registered with go-nmea so it is returned by nmea.Parse
+func newPWLPOS(s nmea.BaseSentence) (nmea.Sentence, error) {
+	p := nmea.NewParser(s)
+	p.AssertType(TypePWLPOS)
+	m := PWLPOSSentence{BaseSentence: s}
+	if len(s.Fields) < pwlPosFields {
+		p.SetErr("fields", fmt.Sprintf("expected at least %d fields, got %d", pwlPosFields, len(s.Fields)))
+		return m, p.Err()
+	}
+	t := p.Time(0, "time")
+	m.TimeUTC = time.Date(0, 1, 1, t.Hour, t.Minute, t.Second, t.Millisecond*int(time.Millisecond), time.UTC)
+	m.Valid = p.String(11, "x") != ""
+	if m.Valid {
+		if p.String(1, "latitude") != "" {
+			m.Global.Latitude = p.LatLong(1, 2, "latitude")
+			m.Global.Longitude = p.LatLong(3, 4, "longitude")
+		}
+		m.Global.FixQuality = p.Float64(5, "fix quality")
+		m.Global.NumSats = p.Float64(6, "number of satellites")
+		m.Global.Hdop = p.Float64(7, "hdop")
+		m.Global.Cog = p.Float64(8, "cog")
+		m.Global.Sog = p.Float64(9, "sog")
+		m.Global.Orientation = p.Float64(10, "orientation")
+		m.Acoustic.X = p.Float64(11, "x")
+		m.Acoustic.Y = p.Float64(12, "y")
+		m.Acoustic.Z = p.Float64(13, "z")
+		m.Acoustic.Std = p.Float64(14, "std")
+	}
+	m.Acoustic.PositionValid = p.EnumString(15, "status", "A", "V") == "A"
+	receivers := int(p.Int64(16, "receivers"))
+	if p.Err() == nil && len(s.Fields) != pwlPosFields+receivers*pwlPosReceiverFields {
+		p.SetErr("receivers", fmt.Sprintf("expected %d receivers, got %d fields", receivers, len(s.Fields)))
+	}
+	if p.Err() != nil || receivers == 0 {
+		return m, p.Err()
+	}
+	for i := pwlPosFields; i < len(s.Fields); i += pwlPosReceiverFields {
+		m.Acoustic.ReceiverDistance = append(m.Acoustic.ReceiverDistance, p.Float64(i, "receiver distance"))
+		m.Acoustic.ReceiverNsd = append(m.Acoustic.ReceiverNsd, p.Float64(i+1, "receiver nsd"))
+		m.Acoustic.ReceiverRssi = append(m.Acoustic.ReceiverRssi, p.Float64(i+2, "receiver rssi"))
+		m.Acoustic.ReceiverValid = append(m.Acoustic.ReceiverValid, p.EnumString(i+3, "receiver status", "A", "V") == "A")
+	}
+	return m, p.Err()
+}
+
+/*
+AIVDM structure represents !--VDM and !--VDO messages with an AIS message.
+VDM is an AIS message received from another vessel, VDO is from own vessel.
+https://gpsd.gitlab.io/gpsd/AIVDM.html
+
+Fields:
+1. Number of fragments
+2. Fragment number
+3. Sequential message ID for multi-sentence messages
+4. Radio channel, A or B
+5. Payload in 6-bit ASCII armoring
+6. Number of fill bits
+7. Checksum
+
+Example: !AIVDM,1,1,,B,177KQJ5000G?tO`K>RA1wUbN0TKH,0*5C
+*/
+type AIVDM struct {
+	// Talker is the talker ID, AI if empty
+	Talker string
+	// Sentence is VDM or VDO
+	Sentence string
+	// MessageID is the sequential message ID (0-9) used if the message needs several sentences
+	MessageID int
+	Channel   string
+	// Payload is the AIS message, one bit in each byte
+	Payload []byte
+}
+
+// aisMaxPayloadChars is the maximum length of the payload in one sentence
+const aisMaxPayloadChars = 60
+
+// Serialise returns the sentences with the message, separated by CR LF if the message needs several sentences
+func (sentence AIVDM) Serialise() string {
+	payload, fill := armor(sentence.Payload)
+	var fragments []string
+	for len(payload) > aisMaxPayloadChars {
+		fragments = append(fragments, payload[:aisMaxPayloadChars])
+		payload = payload[aisMaxPayloadChars:]
+	}
+	fragments = append(fragments, payload)
+
+	messageID := ""
+	if len(fragments) > 1 {
+		messageID = fmt.Sprintf("%d", sentence.MessageID)
+	}
+	sentences := make([]string, len(fragments))
+	for i, fragment := range fragments {
+		// Only the last sentence has fill bits
+		fragmentFill := 0
+		if i == len(fragments)-1 {
+			fragmentFill = fill
+		}
+		sentences[i] = assembleEncapsulationSentence([]string{talkerID(sentence.Talker, "AI") + sentence.Sentence,
+			fmt.Sprintf("%d", len(fragments)), fmt.Sprintf("%d", i+1), messageID, sentence.Channel,
+			fragment, fmt.Sprintf("%d", fragmentFill)})
+	}
+	return strings.Join(sentences, "\r\n")
+}
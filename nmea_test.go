@@ -7,6 +7,8 @@ import (
 
 	"github.com/adrianmo/go-nmea"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/waterlinked/ugps-go/ugps"
 )
 
 func TestPrependZero(t *testing.T) {
@@ -201,3 +203,211 @@ func TestFuzz(t *testing.T) {
 		assert.InDelta(t, float64(r.Longitude), nm.Longitude, 0.00001)
 	}
 }
+
+func TestGLL(t *testing.T) {
+	r := GPGLL{
+		TimeUTC:          time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+		Latitude:         -1.23,
+		Longitude:        2.34,
+		QualityIndicator: 1,
+	}
+	res := r.SerialiseDecimals(1)
+	assert.Equal(t, "$GPGLL,0113.8,S,00220.4,E,203458.651,A,A*41", res)
+
+	back, err := nmea.Parse(r.Serialise())
+	require.NoError(t, err)
+	gll := back.(nmea.GLL)
+	assert.InDelta(t, -1.23, gll.Latitude, 0.00001)
+	assert.InDelta(t, 2.34, gll.Longitude, 0.00001)
+	assert.Equal(t, nmea.ValidGLL, gll.Validity)
+	assert.Equal(t, "A", gll.FFAMode)
+
+	back, err = nmea.Parse(GPGLL{}.Serialise())
+	require.NoError(t, err)
+	assert.Equal(t, nmea.InvalidGLL, back.(nmea.GLL).Validity)
+}
+
+func TestRMC(t *testing.T) {
+	r := GPRMC{
+		TimeUTC:          time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+		Latitude:         63.44,
+		Longitude:        -10.38,
+		QualityIndicator: 2,
+		Sog:              1.234,
+		Cog:              271.55,
+	}
+	res := r.SerialiseDecimals(1)
+	assert.Equal(t, "$GPRMC,203458.651,A,6326.4,N,01022.8,W,1.23,271.6,171109,,,D*42", res)
+
+	back, err := nmea.Parse(r.Serialise())
+	require.NoError(t, err)
+	rmc := back.(nmea.RMC)
+	assert.InDelta(t, 63.44, rmc.Latitude, 0.00001)
+	assert.InDelta(t, -10.38, rmc.Longitude, 0.00001)
+	assert.Equal(t, nmea.ValidRMC, rmc.Validity)
+	assert.Equal(t, 1.23, rmc.Speed)
+	assert.Equal(t, 271.6, rmc.Course)
+	assert.Equal(t, nmea.Date{Valid: true, DD: 17, MM: 11, YY: 9}, rmc.Date)
+	assert.Equal(t, 34, rmc.Time.Minute)
+
+	back, err = nmea.Parse(GPRMC{}.Serialise())
+	require.NoError(t, err)
+	assert.Equal(t, nmea.InvalidRMC, back.(nmea.RMC).Validity)
+}
+
+func TestGNS(t *testing.T) {
+	r := GPGNS{
+		TimeUTC:                time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+		Latitude:               1.23,
+		Longitude:              2.34,
+		QualityIndicator:       1,
+		NumberOfSatellitesUsed: 9,
+		Hdop:                   0.8,
+		Altitude:               -12.5,
+	}
+	res := r.SerialiseDecimals(1)
+	assert.Equal(t, "$GPGNS,203458.651,0113.8,N,00220.4,E,A,09,0.8,-12.50,,,*06", res)
+
+	back, err := nmea.Parse(r.Serialise())
+	require.NoError(t, err)
+	gns := back.(nmea.GNS)
+	assert.InDelta(t, 1.23, gns.Latitude, 0.00001)
+	assert.InDelta(t, 2.34, gns.Longitude, 0.00001)
+	assert.Equal(t, []string{nmea.AutonomousGNS}, gns.Mode)
+	assert.Equal(t, int64(9), gns.SVs)
+	assert.Equal(t, 0.8, gns.HDOP)
+	assert.Equal(t, -12.5, gns.Altitude)
+
+	back, err = nmea.Parse(GPGNS{}.Serialise())
+	require.NoError(t, err)
+	assert.Equal(t, []string{nmea.NoFixGNS}, back.(nmea.GNS).Mode)
+}
+
+func TestVTG(t *testing.T) {
+	r := GPVTG{QualityIndicator: 1, Sog: 2.5, Cog: 45.04}
+	res := r.Serialise()
+	assert.Equal(t, "$GPVTG,45.0,T,,M,2.50,N,4.63,K,A*3A", res)
+
+	back, err := nmea.Parse(res)
+	require.NoError(t, err)
+	vtg := back.(nmea.VTG)
+	assert.Equal(t, 45.0, vtg.TrueTrack)
+	assert.Equal(t, 2.5, vtg.GroundSpeedKnots)
+	assert.Equal(t, 4.63, vtg.GroundSpeedKPH)
+
+	assert.Equal(t, "$GPVTG,,T,,M,,N,,K,N*2C", GPVTG{}.Serialise())
+}
+
+func TestDepthSentences(t *testing.T) {
+	res := SDDPT{Valid: true, Depth: 12.5}.Serialise()
+	assert.Equal(t, "$SDDPT,12.50,0.0,*7D", res)
+	back, err := nmea.Parse(res)
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, back.(nmea.DPT).Depth)
+	assert.Equal(t, "$SDDPT,,,*7B", SDDPT{}.Serialise())
+
+	res = SDDBT{Valid: true, Depth: 12.5}.Serialise()
+	assert.Equal(t, "$SDDBT,41.01,f,12.50,M,6.84,F*3E", res)
+	back, err = nmea.Parse(res)
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, back.(nmea.DBT).DepthMeters)
+	assert.Equal(t, "$SDDBT,,f,,M,,F*28", SDDBT{}.Serialise())
+
+	res = YXXDR{Valid: true, Depth: 12.5, Name: "LOCATOR"}.Serialise()
+	assert.Equal(t, "$YXXDR,D,12.50,M,LOCATOR*26", res)
+	back, err = nmea.Parse(res)
+	require.NoError(t, err)
+	depth, ok, err := (&xdrDepthParser{name: "LOCATOR"}).parseNMEA(back)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 12.5, depth)
+	assert.Equal(t, "$YXXDR,D,,M,LOCATOR*0E", YXXDR{Name: "LOCATOR"}.Serialise())
+
+	date := time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC)
+	assert.Equal(t, "$PWLDEP,203458.651,12.50,M,A*2A", PWLDEP{TimeUTC: date, Valid: true, Depth: 12.5}.Serialise())
+	assert.Equal(t, "$PWLDEP,203458.651,,M,V*15", PWLDEP{TimeUTC: date}.Serialise())
+}
+
+func TestPWLPOS(t *testing.T) {
+	date := time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC)
+	sentence := PWLPOS{
+		TimeUTC: date,
+		Valid:   true,
+		Global: ugps.GlobalPosition{Latitude: 63.4, Longitude: -10.4, FixQuality: 2, NumSats: 12, Hdop: 0.7,
+			Cog: 123.4, Sog: 1.5, Orientation: 45},
+		Acoustic: ugps.AcousticPosition{X: 10.5, Y: -3.25, Z: 12.5, Std: 0.35, PositionValid: true,
+			ReceiverDistance: []float64{14.02, 14.11}, ReceiverNsd: []float64{-50.1, -49.8},
+			ReceiverRssi: []float64{-20.3, -22}, ReceiverValid: []bool{true, false}},
+	}
+	res := sentence.Serialise()
+	assert.Equal(t, "$PWLPOS,203458.651,6324.000000,N,01024.000000,W,2,12,0.7,123.4,1.50,45.0,10.50,-3.25,12.50,0.35,A,2,14.02,-50.1,-20.3,A,14.11,-49.8,-22.0,V*6E", res)
+
+	back, err := nmea.Parse(res)
+	require.NoError(t, err)
+	pos, ok := back.(PWLPOSSentence)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(0, 1, 1, 20, 34, 58, 651000000, time.UTC), pos.TimeUTC)
+	assert.InDelta(t, 63.4, pos.Global.Latitude, 1e-9)
+	assert.InDelta(t, -10.4, pos.Global.Longitude, 1e-9)
+	pos.Global.Latitude, pos.Global.Longitude = sentence.Global.Latitude, sentence.Global.Longitude
+	pos.TimeUTC = date
+	assert.Equal(t, sentence, pos.PWLPOS)
+
+	// No position from the Underwater GPS
+	res = PWLPOS{TimeUTC: date}.Serialise()
+	assert.Equal(t, "$PWLPOS,203458.651,,,,,,,,,,,,,,,V,0*59", res)
+	back, err = nmea.Parse(res)
+	require.NoError(t, err)
+	pos = back.(PWLPOSSentence)
+	assert.False(t, pos.Valid)
+	assert.Equal(t, ugps.AcousticPosition{}, pos.Acoustic)
+
+	for _, invalid := range []string{
+		"$PWLPOS,203458.651,,,,,,,,,,,,,,,V*45",
+		"$PWLPOS,203458.651,,,,,,,,,,,,,,,V,1*58",
+		"$PWLPOS,203458.651,,,,,,,,,,,,,,,X,0*57",
+	} {
+		_, err := nmea.Parse(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTalker(t *testing.T) {
+	assert.True(t, validTalker("GN"))
+	assert.True(t, validTalker("II"))
+	assert.False(t, validTalker("G"))
+	assert.False(t, validTalker("GPS"))
+	assert.False(t, validTalker("gn"))
+	assert.False(t, validTalker("G1"))
+
+	date := time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC)
+	assert.Equal(t, "$GNGGA,203458.651,,,,,0,0,,0.00,M,,M,,*42", GAGGA{Talker: "GN", TimeUTC: date}.Serialise())
+	assert.Equal(t, "$SDTLL,0,,,,,,203458.651,L*2B", RATLL{Talker: "SD", TimeUTC: date}.Serialise())
+	assert.Equal(t, "$IIVTG,,T,,M,,N,,K,N*3B", GPVTG{Talker: "II"}.Serialise())
+}
+
+func TestTTM(t *testing.T) {
+	r := RATTM{
+		TimeUTC:      time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+		TargetNum:    1,
+		Distance:     0.0512,
+		Bearing:      271.55,
+		Sog:          0.5,
+		Cog:          12.34,
+		TargetName:   "ROV",
+		TargetStatus: 'T',
+	}
+	res := r.Serialise()
+	assert.Equal(t, "$RATTM,01,0.0512,271.6,T,0.50,12.3,T,,,N,ROV,T,,203458.651,A*46", res)
+
+	back, err := nmea.Parse(res)
+	require.NoError(t, err)
+	ttm := back.(nmea.TTM)
+	assert.Equal(t, int64(1), ttm.TargetNumber)
+	assert.Equal(t, 0.0512, ttm.TargetDistance)
+	assert.Equal(t, 271.6, ttm.Bearing)
+	assert.Equal(t, 12.3, ttm.TargetCourse)
+	assert.Equal(t, "ROV", ttm.TargetName)
+
+	assert.Equal(t, "$RATTM,00,,,T,,,T,,,N,,L,,000000.000,A*2F", RATTM{}.Serialise())
+}
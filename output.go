@@ -1,12 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"math"
+	"slices"
 	"time"
+
+	"github.com/waterlinked/ugps-go/ugps"
 )
 
+// outputPollInterval is the time between polling the UGPS for the Locator position (10 Hz)
+const outputPollInterval = 100 * time.Millisecond
+
+type destinationStats struct {
+	sendOk   int
+	errCount int
+	errMsg   string
+	// conn is the connection status if reported by the writer
+	conn connectionStatus
+}
+
 type outputStats struct {
 	src struct {
 		getOk    int
@@ -14,74 +29,282 @@ type outputStats struct {
 		getErr   int
 		errMsg   string
 	}
-	dst struct {
-		sendOk   int
-		errCount int
-		errMsg   string
+	// dst has the stats for each destination in the order they were added
+	dst []destinationStats
+}
+
+// positionEncoder writes the position in the format of a destination, NMEA 0183 sentences or NMEA 2000 messages
+type positionEncoder interface {
+	writePosition(w io.Writer, fix locatorFix) error
+	// writeNoPosition tells the receiver that the position is lost
+	writeNoPosition(w io.Writer) error
+}
+
+// topsideUser is implemented by encoders and serialisers which need the topside position.
+// The topside position is only fetched from the UGPS if used.
+type topsideUser interface {
+	usesTopside() bool
+}
+
+// usesTopside returns true if v is a topsideUser using the topside position
+func usesTopside(v any) bool {
+	user, ok := v.(topsideUser)
+	return ok && user.usesTopside()
+}
+
+// outputDestination is a writer the Locator position is sent to
+type outputDestination struct {
+	writer  io.Writer
+	encoder positionEncoder
+	// interval is the minimum time between positions written
+	interval  time.Duration
+	lastWrite time.Time
+	// pending is true if the latest position is not written yet
+	pending bool
+}
+
+// locatorFix is the positions from the UGPS sent to the outputs
+type locatorFix struct {
+	// global is the Locator position with course and speed over ground computed by the bridge
+	global ugps.GlobalPosition
+	// reported is the Locator position as reported by the UGPS, with the course and speed over ground from the UGPS
+	reported ugps.GlobalPosition
+	acoustic ugps.AcousticPosition
+	// master is the topside position and heading, nil if not used by the outputs or it could not be fetched
+	master *ugps.GlobalPosition
+	// targets are the target positions by endpoint with course and speed over ground.
+	// Targets which could not be fetched are missing.
+	targets map[string]ugps.GlobalPosition
+}
+
+// distanceNorthEast returns the distance in meters north and east from one position to another nearby
+func distanceNorthEast(from ugps.GlobalPosition, to ugps.GlobalPosition) (float64, float64) {
+	north := (to.Latitude - from.Latitude) * math.Pi / 180 * earthRadius
+	east := (to.Longitude - from.Longitude) * math.Pi / 180 * earthRadius * math.Cos(to.Latitude*math.Pi/180)
+	return north, east
+}
+
+// targetMotion computes the course and speed over ground of a target from successive positions
+type targetMotion struct {
+	previous     ugps.GlobalPosition
+	previousTime time.Time
+}
+
+// update sets Cog in degrees true and Sog in knots of the new position.
+// Both are 0 for the first position.
+func (m *targetMotion) update(position ugps.GlobalPosition, now time.Time) ugps.GlobalPosition {
+	position.Cog = 0
+	position.Sog = 0
+	if !m.previousTime.IsZero() {
+		north, east := distanceNorthEast(m.previous, position)
+		if elapsed := now.Sub(m.previousTime).Seconds(); elapsed > 0 {
+			position.Cog = normaliseHeading(math.Atan2(east, north) * 180 / math.Pi)
+			position.Sog = math.Hypot(north, east) / elapsed * 3600 / metersPerNauticalMile
+		}
 	}
+	m.previous = position
+	m.previousTime = now
+	return position
+}
+
+// reset forgets the previous position when the position is lost
+func (m *targetMotion) reset() {
+	*m = targetMotion{}
 }
 
 type Outputter struct {
-	writer              io.Writer
+	client       *ugps.Client
+	destinations []*outputDestination
+	// endpoints are the UGPS endpoints polled for target positions in addition to the Locator and topside
+	endpoints []string
+	// fetchTopside is true if any destination uses the topside position
+	fetchTopside        bool
+	motions             map[string]*targetMotion
 	stats               outputStats
 	outputStatusChannel chan outputStats
-	serialiser          nmeaPositionSerialiser
 }
 
-func NewOutputter(writer io.Writer, serialiser nmeaPositionSerialiser) *Outputter {
-	return &Outputter{writer: writer, stats: outputStats{}, outputStatusChannel: make(chan outputStats, 1), serialiser: serialiser}
+// NewOutputter creates an outputter sending the Locator position and the positions of the targets
+func NewOutputter(client *ugps.Client, targets []outputTarget) *Outputter {
+	outputter := &Outputter{
+		client:              client,
+		motions:             make(map[string]*targetMotion),
+		stats:               outputStats{},
+		outputStatusChannel: make(chan outputStats, 1),
+	}
+	for _, endpoint := range []string{locatorEndpoint, masterEndpoint} {
+		outputter.motions[endpoint] = &targetMotion{}
+	}
+	for _, target := range targets {
+		if _, exists := outputter.motions[target.endpoint]; !exists {
+			outputter.endpoints = append(outputter.endpoints, target.endpoint)
+			outputter.motions[target.endpoint] = &targetMotion{}
+		}
+	}
+	return outputter
 }
 
-func (outputter *Outputter) handleSrcError(err error, message string) {
+// addDestination writes each new position to writer using encoder.
+// rate is the maximum number of positions per second, 0 writes all positions.
+func (outputter *Outputter) addDestination(writer io.Writer, encoder positionEncoder, rate float64) {
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	outputter.destinations = append(outputter.destinations, &outputDestination{writer: writer, encoder: encoder, interval: interval})
+	outputter.fetchTopside = outputter.fetchTopside || usesTopside(encoder)
+	outputter.stats.dst = append(outputter.stats.dst, destinationStats{})
+}
+
+// sendStats sends a copy of the stats as the destination stats are modified after sending
+func (outputter *Outputter) sendStats(ctx context.Context) {
+	stats := outputter.stats
+	stats.dst = slices.Clone(outputter.stats.dst)
+	sendStats(ctx, outputter.outputStatusChannel, stats)
+}
+
+func (outputter *Outputter) handleSrcError(ctx context.Context, err error, message string) {
+	if ctx.Err() != nil {
+		// Shutting down, not an error
+		return
+	}
 	outputter.stats.src.errMsg = fmt.Sprintf("%s: %v", message, err)
 	debugPrintf(outputter.stats.src.errMsg)
 	outputter.stats.src.getErr++
-	outputter.outputStatusChannel <- outputter.stats
 
-	output := outputter.serialiser.noPosition()
-	fmt.Fprintf(outputter.writer, "%s\r\n", output)
+	for _, motion := range outputter.motions {
+		motion.reset()
+	}
+	outputter.writeNoPosition(ctx)
+}
+
+// writeNoPosition tells the receivers that the position is lost and sends the stats
+func (outputter *Outputter) writeNoPosition(ctx context.Context) {
+	for i, destination := range outputter.destinations {
+		destination.pending = false
+		outputter.updateDestinationStats(i, destination.encoder.writeNoPosition(destination.writer))
+	}
+	outputter.sendStats(ctx)
+}
+
+// write writes the position to the destination with the given index
+func (outputter *Outputter) write(index int, fix locatorFix) {
+	destination := outputter.destinations[index]
+	err := destination.encoder.writePosition(destination.writer, fix)
+	if err == nil {
+		outputter.stats.dst[index].sendOk++
+	}
+	outputter.updateDestinationStats(index, err)
+}
+
+// updateDestinationStats updates the error and connection status of the destination with the given index after writing
+func (outputter *Outputter) updateDestinationStats(index int, err error) {
+	destination := outputter.destinations[index]
+	stats := &outputter.stats.dst[index]
+
+	if err != nil {
+		message := "Error in writing NMEA string"
+		stats.errMsg = fmt.Sprintf("%s: %v", message, err)
+		stats.errCount++
+	} else {
+		stats.errMsg = ""
+	}
+	if reporter, ok := destination.writer.(connectionStatusReporter); ok {
+		stats.conn = reporter.connectionStatus()
+	}
 }
 
-func (outputter *Outputter) OutputLoop() {
-	var previousLatitude float64
-	var previousLongitude float64
+// OutputLoop polls the UGPS for the Locator position and writes it until the context is cancelled.
+// A final "no position" sentence is written before returning.
+func (outputter *Outputter) OutputLoop(ctx context.Context) {
+	defer outputter.writeNoPosition(ctx)
+
+	var fix locatorFix
 	for {
-		time.Sleep(100 * time.Millisecond) // Maximum polling speed 10 Hz
-		globalPosition, err := getGlobalPosition()
+		// Maximum polling speed 10 Hz
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(outputPollInterval):
+		}
+		newGlobalPosition, err := outputter.client.GlobalPosition(ctx)
 		if err != nil {
-			outputter.handleSrcError(err, "Error fetching global position from UGPS")
+			outputter.handleSrcError(ctx, err, "Error fetching global position from UGPS")
 			continue
 		}
-		acousticPosition, err := getAcousticPosition()
+		newAcousticPosition, err := outputter.client.AcousticPosition(ctx)
 		if err != nil {
-			outputter.handleSrcError(err, "Error fetching acoustic position from UGPS")
+			outputter.handleSrcError(ctx, err, "Error fetching acoustic position from UGPS")
 			continue
 		}
-		outputter.stats.src.getOk++
-		outputter.stats.src.errMsg = ""
 
 		// Check if position has changed
-		if math.Abs((globalPosition.Latitude-previousLatitude)) < 1e-12 &&
-			math.Abs((globalPosition.Longitude-previousLongitude)) < 1e-12 {
-			// Not changed
+		if math.Abs((newGlobalPosition.Latitude-fix.global.Latitude)) < 1e-12 &&
+			math.Abs((newGlobalPosition.Longitude-fix.global.Longitude)) < 1e-12 {
+			outputter.stats.src.getOk++
+			outputter.stats.src.errMsg = ""
+			outputter.writePending(ctx, fix)
 			continue
 		}
-		outputter.stats.src.getCount++
 
-		previousLatitude = globalPosition.Latitude
-		previousLongitude = globalPosition.Longitude
+		outputter.stats.src.getOk++
+		outputter.stats.src.errMsg = ""
+		outputter.stats.src.getCount++
 
-		output := outputter.serialiser.serialise(globalPosition, acousticPosition)
+		now := time.Now()
+		fix = locatorFix{
+			global:   outputter.motions[locatorEndpoint].update(newGlobalPosition, now),
+			reported: newGlobalPosition,
+			acoustic: newAcousticPosition,
+			targets:  make(map[string]ugps.GlobalPosition),
+		}
+		fix.targets[locatorEndpoint] = fix.global
+		// The topside and the other targets are only needed with a new Locator position
+		if outputter.fetchTopside {
+			newMasterPosition, err := outputter.client.MasterPosition(ctx)
+			if err == nil {
+				master := outputter.motions[masterEndpoint].update(newMasterPosition, now)
+				fix.master = &master
+				fix.targets[masterEndpoint] = master
+			} else if ctx.Err() == nil {
+				// Only the sentences using the topside position are sent as lost
+				outputter.stats.src.errMsg = fmt.Sprintf("Error fetching topside position from UGPS: %v", err)
+				debugPrintf(outputter.stats.src.errMsg)
+				outputter.motions[masterEndpoint].reset()
+			}
+		}
+		for _, endpoint := range outputter.endpoints {
+			position, err := outputter.client.Position(ctx, endpoint)
+			if err != nil {
+				// The target is sent as lost
+				debugPrintf("Error fetching target position from UGPS %s: %v", endpoint, err)
+				outputter.motions[endpoint].reset()
+				continue
+			}
+			fix.targets[endpoint] = outputter.motions[endpoint].update(position, now)
+		}
+		for _, destination := range outputter.destinations {
+			destination.pending = true
+		}
+		outputter.writePending(ctx, fix)
+	}
+}
 
-		_, err = fmt.Fprintf(outputter.writer, "%s\r\n", output)
-		if err != nil {
-			message := "Error in writing NMEA string"
-			outputter.stats.dst.errMsg = fmt.Sprintf("%s: %v", message, err)
-			outputter.stats.dst.errCount++
-		} else {
-			outputter.stats.dst.errMsg = ""
-			outputter.stats.dst.sendOk++
+// writePending writes the latest position to the destinations where it is not written yet,
+// unless the time since the last write is less than the destination interval
+func (outputter *Outputter) writePending(ctx context.Context, fix locatorFix) {
+	now := time.Now()
+	written := false
+	for i, destination := range outputter.destinations {
+		if !destination.pending || now.Sub(destination.lastWrite) < destination.interval {
+			continue
 		}
-		outputter.outputStatusChannel <- outputter.stats
+		outputter.write(i, fix)
+		destination.pending = false
+		destination.lastWrite = now
+		written = true
+	}
+	if written {
+		outputter.sendStats(ctx)
 	}
 }
@@ -1,14 +1,82 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
 	"time"
 )
 
 type nmeaPositionSerialiser interface {
-	serialise(GlobalPosition, AcousticPosition) string
+	serialise(fix locatorFix) string
 	noPosition() string
+	// withTalker returns the serialiser using the talker ID, or the default talker ID of the sentence if empty
+	withTalker(talker string) nmeaPositionSerialiser
 }
 
+// nmeaSentences writes a NMEA 0183 sentence from each serialiser
+type nmeaSentences []nmeaPositionSerialiser
+
+func (sentences nmeaSentences) writePosition(w io.Writer, fix locatorFix) error {
+	for _, serialiser := range sentences {
+		if _, err := fmt.Fprintf(w, "%s\r\n", serialiser.serialise(fix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sentences nmeaSentences) usesTopside() bool {
+	return slices.ContainsFunc(sentences, func(serialiser nmeaPositionSerialiser) bool { return usesTopside(serialiser) })
+}
+
+func (sentences nmeaSentences) writeNoPosition(w io.Writer) error {
+	for _, serialiser := range sentences {
+		if _, err := fmt.Fprintf(w, "%s\r\n", serialiser.noPosition()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proprietarySerialiser is a serialiser of a proprietary sentence, which has no talker ID
+type proprietarySerialiser interface {
+	proprietary()
+}
+
+// targetSerialiser is a serialiser which sends one sentence for each target
+type targetSerialiser interface {
+	withTarget(target outputTarget) nmeaPositionSerialiser
+}
+
+const (
+	// locatorEndpoint is the UGPS endpoint with the Locator position
+	locatorEndpoint = "/api/v1/position/global"
+	// masterEndpoint is the UGPS endpoint with the topside position
+	masterEndpoint = "/api/v1/position/master"
+)
+
+// targetEndpoints are names which can be used for the target endpoints
+var targetEndpoints = map[string]string{
+	"locator": locatorEndpoint,
+	"master":  masterEndpoint,
+	"topside": masterEndpoint,
+}
+
+// outputTarget is a position from the UGPS sent as a tracked target
+type outputTarget struct {
+	// endpoint is the path of the UGPS position endpoint
+	endpoint string
+	number   int
+	name     string
+	// mmsi identifies the target in AIS messages
+	mmsi int
+}
+
+// defaultTarget is the Locator, used if no targets are configured
+var defaultTarget = outputTarget{endpoint: locatorEndpoint, number: 1, name: "ROV"}
+
 // QualityNoFix represents no fix in an GGA sentence
 const QualityNoFix = 0
 
@@ -18,17 +86,20 @@ const TargetStatusTracking = 'T'
 // TargetStatusLost represents loosing the tracked target
 const TargetStatusLost = 'L'
 
-type ggaSerialiser struct{}
+type ggaSerialiser struct {
+	talker string
+}
 
-func (serialiser ggaSerialiser) serialise(globalPosition GlobalPosition, acousticPosition AcousticPosition) string {
+func (serialiser ggaSerialiser) serialise(fix locatorFix) string {
 	sentence := GAGGA{
+		Talker:                 serialiser.talker,
 		TimeUTC:                time.Now().UTC(),
-		Latitude:               Lat(globalPosition.Latitude),
-		Longitude:              Lng(globalPosition.Longitude),
-		QualityIndicator:       globalPosition.FixQuality,
-		Hdop:                   globalPosition.Hdop,
-		NumberOfSatellitesUsed: int(globalPosition.NumSats),
-		Altitude:               -acousticPosition.Z,
+		Latitude:               Lat(fix.global.Latitude),
+		Longitude:              Lng(fix.global.Longitude),
+		QualityIndicator:       fix.global.FixQuality,
+		Hdop:                   fix.global.Hdop,
+		NumberOfSatellitesUsed: int(fix.global.NumSats),
+		Altitude:               -fix.acoustic.Z,
 	}
 	out := sentence.Serialise()
 	return out
@@ -36,6 +107,7 @@ func (serialiser ggaSerialiser) serialise(globalPosition GlobalPosition, acousti
 
 func (serialiser ggaSerialiser) noPosition() string {
 	gga := GAGGA{
+		Talker:                 serialiser.talker,
 		TimeUTC:                time.Now().UTC(),
 		Latitude:               Lat(0),
 		Longitude:              Lng(0),
@@ -47,15 +119,28 @@ func (serialiser ggaSerialiser) noPosition() string {
 	return gga.Serialise()
 }
 
-type tllSerialiser struct{}
+func (serialiser ggaSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return ggaSerialiser{talker: talker}
+}
+
+// tllSerialiser sends the position of the target
+type tllSerialiser struct {
+	talker string
+	target outputTarget
+}
 
-func (serialiser tllSerialiser) serialise(globalPosition GlobalPosition, acousticPosition AcousticPosition) string {
+func (serialiser tllSerialiser) serialise(fix locatorFix) string {
+	position, exists := fix.targets[serialiser.target.endpoint]
+	if !exists {
+		return serialiser.noPosition()
+	}
 	sentence := RATLL{
+		Talker:       serialiser.talker,
 		TimeUTC:      time.Now().UTC(),
-		Latitude:     Lat(globalPosition.Latitude),
-		Longitude:    Lng(globalPosition.Longitude),
-		TargetName:   "ROV",
-		TargetNum:    1,
+		Latitude:     Lat(position.Latitude),
+		Longitude:    Lng(position.Longitude),
+		TargetName:   serialiser.target.name,
+		TargetNum:    serialiser.target.number,
 		TargetStatus: TargetStatusTracking,
 	}
 	return sentence.Serialise()
@@ -63,12 +148,382 @@ func (serialiser tllSerialiser) serialise(globalPosition GlobalPosition, acousti
 
 func (serialiser tllSerialiser) noPosition() string {
 	sentence := RATLL{
+		Talker:       serialiser.talker,
 		TimeUTC:      time.Now().UTC(),
 		Latitude:     Lat(0),
 		Longitude:    Lng(0),
-		TargetName:   "ROV",
-		TargetNum:    1,
+		TargetName:   serialiser.target.name,
+		TargetNum:    serialiser.target.number,
+		TargetStatus: TargetStatusLost,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser tllSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	serialiser.talker = talker
+	return serialiser
+}
+
+func (serialiser tllSerialiser) withTarget(target outputTarget) nmeaPositionSerialiser {
+	serialiser.target = target
+	return serialiser
+}
+
+func (serialiser tllSerialiser) usesTopside() bool {
+	return serialiser.target.endpoint == masterEndpoint
+}
+
+type gllSerialiser struct {
+	talker string
+}
+
+func (serialiser gllSerialiser) serialise(fix locatorFix) string {
+	sentence := GPGLL{
+		Talker:           serialiser.talker,
+		TimeUTC:          time.Now().UTC(),
+		Latitude:         Lat(fix.global.Latitude),
+		Longitude:        Lng(fix.global.Longitude),
+		QualityIndicator: fix.global.FixQuality,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser gllSerialiser) noPosition() string {
+	sentence := GPGLL{
+		Talker:           serialiser.talker,
+		TimeUTC:          time.Now().UTC(),
+		QualityIndicator: QualityNoFix,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser gllSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return gllSerialiser{talker: talker}
+}
+
+// rmcSerialiser uses the course and speed over ground computed from successive Locator positions
+type rmcSerialiser struct {
+	talker string
+}
+
+func (serialiser rmcSerialiser) serialise(fix locatorFix) string {
+	sentence := GPRMC{
+		Talker:           serialiser.talker,
+		TimeUTC:          time.Now().UTC(),
+		Latitude:         Lat(fix.global.Latitude),
+		Longitude:        Lng(fix.global.Longitude),
+		QualityIndicator: fix.global.FixQuality,
+		Cog:              fix.global.Cog,
+		Sog:              fix.global.Sog,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser rmcSerialiser) noPosition() string {
+	sentence := GPRMC{
+		Talker:           serialiser.talker,
+		TimeUTC:          time.Now().UTC(),
+		QualityIndicator: QualityNoFix,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser rmcSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return rmcSerialiser{talker: talker}
+}
+
+type gnsSerialiser struct {
+	talker string
+}
+
+func (serialiser gnsSerialiser) serialise(fix locatorFix) string {
+	sentence := GPGNS{
+		Talker:                 serialiser.talker,
+		TimeUTC:                time.Now().UTC(),
+		Latitude:               Lat(fix.global.Latitude),
+		Longitude:              Lng(fix.global.Longitude),
+		QualityIndicator:       fix.global.FixQuality,
+		Hdop:                   fix.global.Hdop,
+		NumberOfSatellitesUsed: int(fix.global.NumSats),
+		Altitude:               -fix.acoustic.Z,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser gnsSerialiser) noPosition() string {
+	sentence := GPGNS{
+		Talker:           serialiser.talker,
+		TimeUTC:          time.Now().UTC(),
+		QualityIndicator: QualityNoFix,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser gnsSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return gnsSerialiser{talker: talker}
+}
+
+// vtgSerialiser uses the course and speed over ground computed from successive Locator positions
+type vtgSerialiser struct {
+	talker string
+}
+
+func (serialiser vtgSerialiser) serialise(fix locatorFix) string {
+	sentence := GPVTG{
+		Talker:           serialiser.talker,
+		QualityIndicator: fix.global.FixQuality,
+		Cog:              fix.global.Cog,
+		Sog:              fix.global.Sog,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser vtgSerialiser) noPosition() string {
+	sentence := GPVTG{Talker: serialiser.talker, QualityIndicator: QualityNoFix}
+	return sentence.Serialise()
+}
+
+func (serialiser vtgSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return vtgSerialiser{talker: talker}
+}
+
+// dptSerialiser sends the depth of the Locator below the surface in DPT
+type dptSerialiser struct {
+	talker string
+}
+
+func (serialiser dptSerialiser) serialise(fix locatorFix) string {
+	return SDDPT{Talker: serialiser.talker, Valid: true, Depth: fix.acoustic.Z}.Serialise()
+}
+
+func (serialiser dptSerialiser) noPosition() string {
+	return SDDPT{Talker: serialiser.talker}.Serialise()
+}
+
+func (serialiser dptSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return dptSerialiser{talker: talker}
+}
+
+// dbtSerialiser sends the depth of the Locator below the surface in DBT
+type dbtSerialiser struct {
+	talker string
+}
+
+func (serialiser dbtSerialiser) serialise(fix locatorFix) string {
+	return SDDBT{Talker: serialiser.talker, Valid: true, Depth: fix.acoustic.Z}.Serialise()
+}
+
+func (serialiser dbtSerialiser) noPosition() string {
+	return SDDBT{Talker: serialiser.talker}.Serialise()
+}
+
+func (serialiser dbtSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return dbtSerialiser{talker: talker}
+}
+
+// xdrDepthName is the transducer name of the Locator depth in XDR
+const xdrDepthName = "LOCATOR"
+
+// xdrSerialiser sends the depth of the Locator below the surface as a depth measurement in XDR
+type xdrSerialiser struct {
+	talker string
+}
+
+func (serialiser xdrSerialiser) serialise(fix locatorFix) string {
+	return YXXDR{Talker: serialiser.talker, Valid: true, Depth: fix.acoustic.Z, Name: xdrDepthName}.Serialise()
+}
+
+func (serialiser xdrSerialiser) noPosition() string {
+	return YXXDR{Talker: serialiser.talker, Name: xdrDepthName}.Serialise()
+}
+
+func (serialiser xdrSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return xdrSerialiser{talker: talker}
+}
+
+// pwlDepSerialiser sends the depth of the Locator in the proprietary PWLDEP sentence which has no talker ID
+type pwlDepSerialiser struct{}
+
+func (serialiser pwlDepSerialiser) serialise(fix locatorFix) string {
+	return PWLDEP{TimeUTC: time.Now().UTC(), Valid: true, Depth: fix.acoustic.Z}.Serialise()
+}
+
+func (serialiser pwlDepSerialiser) noPosition() string {
+	return PWLDEP{TimeUTC: time.Now().UTC()}.Serialise()
+}
+
+func (serialiser pwlDepSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return serialiser
+}
+
+func (serialiser pwlDepSerialiser) proprietary() {}
+
+// pwlPosSerialiser sends the global and acoustic position of the Locator in the proprietary PWLPOS sentence
+// which has no talker ID. The course and speed over ground are from the UGPS.
+type pwlPosSerialiser struct{}
+
+func (serialiser pwlPosSerialiser) serialise(fix locatorFix) string {
+	return PWLPOS{TimeUTC: time.Now().UTC(), Valid: true, Global: fix.reported, Acoustic: fix.acoustic}.Serialise()
+}
+
+func (serialiser pwlPosSerialiser) noPosition() string {
+	return PWLPOS{TimeUTC: time.Now().UTC()}.Serialise()
+}
+
+func (serialiser pwlPosSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	return serialiser
+}
+
+func (serialiser pwlPosSerialiser) proprietary() {}
+
+// locatorRangeBearing returns the distance in meters and true bearing in degrees from the topside
+// to the Locator using the acoustic position. fix.master must be set.
+func locatorRangeBearing(fix locatorFix) (float64, float64) {
+	// The acoustic position is relative to the vessel heading, X forward and Y starboard
+	relativeBearing := math.Atan2(fix.acoustic.Y, fix.acoustic.X) * 180 / math.Pi
+	return math.Hypot(fix.acoustic.X, fix.acoustic.Y), normaliseHeading(fix.master.Orientation + relativeBearing)
+}
+
+// ttmSerialiser sends the target as tracked by the topside with range and bearing from the topside,
+// and course and speed over ground computed from successive positions.
+// The range and bearing of the Locator is from the acoustic position.
+type ttmSerialiser struct {
+	talker string
+	target outputTarget
+}
+
+func (serialiser ttmSerialiser) serialise(fix locatorFix) string {
+	position, exists := fix.targets[serialiser.target.endpoint]
+	// The range and bearing is from the topside
+	if !exists || fix.master == nil {
+		return serialiser.noPosition()
+	}
+	var distance, bearing float64
+	if serialiser.target.endpoint == locatorEndpoint {
+		distance, bearing = locatorRangeBearing(fix)
+	} else {
+		north, east := distanceNorthEast(*fix.master, position)
+		distance = math.Hypot(north, east)
+		bearing = normaliseHeading(math.Atan2(east, north) * 180 / math.Pi)
+	}
+	sentence := RATTM{
+		Talker:       serialiser.talker,
+		TimeUTC:      time.Now().UTC(),
+		TargetNum:    serialiser.target.number,
+		Distance:     distance / metersPerNauticalMile,
+		Bearing:      bearing,
+		Sog:          position.Sog,
+		Cog:          position.Cog,
+		TargetName:   serialiser.target.name,
+		TargetStatus: TargetStatusTracking,
+	}
+	return sentence.Serialise()
+}
+
+func (serialiser ttmSerialiser) noPosition() string {
+	sentence := RATTM{
+		Talker:       serialiser.talker,
+		TimeUTC:      time.Now().UTC(),
+		TargetNum:    serialiser.target.number,
+		TargetName:   serialiser.target.name,
 		TargetStatus: TargetStatusLost,
 	}
 	return sentence.Serialise()
 }
+
+func (serialiser ttmSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	serialiser.talker = talker
+	return serialiser
+}
+
+func (serialiser ttmSerialiser) withTarget(target outputTarget) nmeaPositionSerialiser {
+	serialiser.target = target
+	return serialiser
+}
+
+func (serialiser ttmSerialiser) usesTopside() bool {
+	return true
+}
+
+// aisSettings are the AIS output settings from the config file
+type aisSettings struct {
+	// messageType is the position report, 1 (class A) or 18 (class B)
+	messageType int
+	// clock returns the current time, time.Now is used if nil
+	clock func() time.Time
+}
+
+// now returns the current time in UTC
+func (settings *aisSettings) now() time.Time {
+	if settings.clock != nil {
+		return settings.clock().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// aisStaticInterval is the time between static data reports with the name of the target
+const aisStaticInterval = 30 * time.Second
+
+// aisSerialiser sends the target as an AIS position report in VDM or VDO sentences,
+// and a static data report with the name of the target every aisStaticInterval
+type aisSerialiser struct {
+	talker string
+	// sentence is VDM or VDO
+	sentence string
+	settings *aisSettings
+	target   outputTarget
+	// lastStatic is when the static data report was sent, it is set for each target by withTarget
+	lastStatic *time.Time
+}
+
+func (serialiser aisSerialiser) encapsulate(payload []byte) string {
+	sentence := AIVDM{Talker: serialiser.talker, Sentence: serialiser.sentence, Channel: "A", Payload: payload}
+	return sentence.Serialise()
+}
+
+func (serialiser aisSerialiser) serialise(fix locatorFix) string {
+	position, exists := fix.targets[serialiser.target.endpoint]
+	if !exists {
+		return serialiser.noPosition()
+	}
+	now := serialiser.settings.now()
+	out := serialiser.encapsulate(aisPositionReport(serialiser.settings.messageType, aisPosition{
+		mmsi: serialiser.target.mmsi,
+		lat:  position.Latitude,
+		lon:  position.Longitude,
+		cog:  position.Cog,
+		sog:  position.Sog,
+		time: now,
+	}))
+	if serialiser.lastStatic != nil && now.Sub(*serialiser.lastStatic) >= aisStaticInterval {
+		*serialiser.lastStatic = now
+		out += "\r\n" + serialiser.encapsulate(aisStaticDataA(serialiser.target.mmsi, serialiser.target.name)) +
+			"\r\n" + serialiser.encapsulate(aisStaticDataB(serialiser.target.mmsi))
+	}
+	return out
+}
+
+func (serialiser aisSerialiser) noPosition() string {
+	return serialiser.encapsulate(aisPositionReport(serialiser.settings.messageType, aisPosition{
+		mmsi: serialiser.target.mmsi,
+		lat:  math.NaN(),
+		lon:  math.NaN(),
+		cog:  math.NaN(),
+		sog:  math.NaN(),
+	}))
+}
+
+func (serialiser aisSerialiser) withTalker(talker string) nmeaPositionSerialiser {
+	serialiser.talker = talker
+	return serialiser
+}
+
+func (serialiser aisSerialiser) withTarget(target outputTarget) nmeaPositionSerialiser {
+	serialiser.target = target
+	serialiser.lastStatic = &time.Time{}
+	return serialiser
+}
+
+func (serialiser aisSerialiser) usesTopside() bool {
+	return serialiser.target.endpoint == masterEndpoint
+}
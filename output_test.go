@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/waterlinked/ugps-go/ugps"
+)
+
+// newMovingUGPS returns a UGPS client where the Locator moves for each position request
+func newMovingUGPS(t *testing.T) *ugps.Client {
+	var count atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/position/global":
+			n := count.Add(1)
+			fmt.Fprintf(w, `{"lat":63.4,"lon":%f,"fix_quality":1,"numsats":9}`, 10.4+float64(n)*1e-5)
+		case "/api/v1/position/global/2":
+			fmt.Fprint(w, `{"lat":63.5,"lon":10.5,"fix_quality":1}`)
+		case "/api/v1/position/master":
+			fmt.Fprint(w, `{"lat":63.4,"lon":10.4,"orientation":90}`)
+		case "/api/v1/position/acoustic/filtered":
+			fmt.Fprint(w, `{"position_valid":true,"x":1,"y":2,"z":3}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return ugps.NewClient(srv.URL)
+}
+
+func TestOutputterDestinations(t *testing.T) {
+	var all, limited bytes.Buffer
+	outputter := NewOutputter(newMovingUGPS(t), []outputTarget{defaultTarget})
+	tll := tllSerialiser{}.withTarget(defaultTarget)
+	outputter.addDestination(&all, nmeaSentences{ggaSerialiser{}, tll}, 0)
+	outputter.addDestination(&limited, nmeaSentences{tll}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		outputter.OutputLoop(ctx)
+		close(done)
+	}()
+
+	var stats outputStats
+	for stats.src.getCount < 5 {
+		select {
+		case stats = <-outputter.outputStatusChannel:
+		case <-time.After(2 * time.Second):
+			t.Fatal("No output status")
+		}
+	}
+	cancel()
+	<-done
+	// More positions can be written after the last status received
+	stats = outputter.stats
+
+	require.Len(t, stats.dst, 2)
+	assert.GreaterOrEqual(t, stats.dst[0].sendOk, 5)
+	assert.GreaterOrEqual(t, stats.dst[1].sendOk, 1)
+	assert.Less(t, stats.dst[1].sendOk, stats.dst[0].sendOk)
+
+	// Both sentences are written to the first destination, and a final "no position"
+	lines := strings.Split(strings.TrimSpace(all.String()), "\r\n")
+	assert.Equal(t, 2*stats.dst[0].sendOk+2, len(lines))
+	assert.Contains(t, lines[0], "GGA,")
+	assert.Contains(t, lines[1], "TLL,")
+
+	lines = strings.Split(strings.TrimSpace(limited.String()), "\r\n")
+	assert.Equal(t, stats.dst[1].sendOk+1, len(lines))
+	for _, line := range lines {
+		assert.Contains(t, line, "TLL,")
+	}
+}
+
+func TestOutputterTopside(t *testing.T) {
+	var count, masterCount atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/position/global":
+			n := count.Add(1)
+			fmt.Fprintf(w, `{"lat":63.4,"lon":%f,"fix_quality":1,"numsats":9,"cog":12.5,"sog":0.8}`, 10.4+float64(n)*1e-5)
+		case "/api/v1/position/acoustic/filtered":
+			fmt.Fprint(w, `{"position_valid":true,"x":1,"y":2,"z":3}`)
+		default:
+			// Firmware without the topside endpoint
+			if r.URL.Path == masterEndpoint {
+				masterCount.Add(1)
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	run := func(encoder positionEncoder) (string, outputStats) {
+		var buf bytes.Buffer
+		outputter := NewOutputter(ugps.NewClient(srv.URL), []outputTarget{defaultTarget})
+		outputter.addDestination(&buf, encoder, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			outputter.OutputLoop(ctx)
+			close(done)
+		}()
+		var stats outputStats
+		select {
+		case stats = <-outputter.outputStatusChannel:
+		case <-time.After(2 * time.Second):
+			t.Fatal("No output status")
+		}
+		cancel()
+		<-done
+		return buf.String(), stats
+	}
+
+	// The topside position is not fetched when not used
+	out, stats := run(nmeaSentences{ggaSerialiser{}})
+	assert.Equal(t, int64(0), masterCount.Load())
+	assert.Contains(t, out, "GGA,")
+	assert.Equal(t, 1, stats.dst[0].sendOk)
+	assert.Empty(t, stats.src.errMsg)
+
+	// PWLPOS has the course and speed over ground from the UGPS
+	out, _ = run(nmeaSentences{pwlPosSerialiser{}})
+	assert.Contains(t, out, ",1,9,0.0,12.5,0.80,0.0,1.00,2.00,3.00,")
+
+	// Only the sentences using the topside position are sent as lost if it can not be fetched
+	out, stats = run(nmeaSentences{ggaSerialiser{}, ttmSerialiser{}.withTarget(defaultTarget)})
+	assert.Positive(t, masterCount.Load())
+	lines := strings.Split(out, "\r\n")
+	assert.Contains(t, lines[0], "GGA,")
+	assert.Contains(t, lines[0], ",6324.000000,N,")
+	assert.Contains(t, lines[1], ",ROV,L,")
+	assert.Equal(t, 1, stats.dst[0].sendOk)
+	assert.Contains(t, stats.src.errMsg, "Error fetching topside position")
+
+	assert.True(t, usesTopside(newN2KPGNs([]n2kPGNSerialiser{n2kRapidPosition{}, n2kNavigationData{}}, encodeYDRaw)))
+	assert.False(t, usesTopside(newN2KPGNs([]n2kPGNSerialiser{n2kRapidPosition{}}, encodeYDRaw)))
+	assert.True(t, usesTopside(nmeaSentences{tllSerialiser{}.withTarget(outputTarget{endpoint: masterEndpoint})}))
+}
+
+func TestOutputterNoPositionStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	outputter := NewOutputter(ugps.NewClient(srv.URL), []outputTarget{defaultTarget})
+	conn := newReconnectingConn("COM1", func() (io.ReadWriteCloser, error) {
+		return nil, errors.New("no such device")
+	})
+	outputter.addDestination(conn, nmeaSentences{ggaSerialiser{}}, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go outputter.OutputLoop(ctx)
+
+	// The failing output is reported while the UGPS has no position
+	var stats outputStats
+	select {
+	case stats = <-outputter.outputStatusChannel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("No output status")
+	}
+	assert.Contains(t, stats.src.errMsg, "Error fetching global position")
+	require.Len(t, stats.dst, 1)
+	assert.Contains(t, stats.dst[0].errMsg, "no such device")
+	assert.Equal(t, 1, stats.dst[0].errCount)
+	assert.Equal(t, connectionStatus{errMsg: "no such device"}, stats.dst[0].conn)
+}
+
+func TestTargetMotion(t *testing.T) {
+	var motion targetMotion
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	position := motion.update(ugps.GlobalPosition{Latitude: 60, Longitude: 10, Cog: 123, Sog: 4}, start)
+	assert.Equal(t, 0.0, position.Cog)
+	assert.Equal(t, 0.0, position.Sog)
+
+	// 1 knot is 1852 m per hour, moving 1852/60 m west in one minute
+	east := 1852.0 / 60 / (earthRadius * math.Cos(60*math.Pi/180)) * 180 / math.Pi
+	position = motion.update(ugps.GlobalPosition{Latitude: 60, Longitude: 10 - east}, start.Add(time.Minute))
+	assert.InDelta(t, 270, position.Cog, 0.01)
+	assert.InDelta(t, 1, position.Sog, 0.001)
+
+	// Moving north
+	north := 1852.0 / 60 * 2 / earthRadius * 180 / math.Pi
+	position = motion.update(ugps.GlobalPosition{Latitude: 60 + north, Longitude: 10 - east}, start.Add(2*time.Minute))
+	assert.InDelta(t, 0, position.Cog, 0.01)
+	assert.InDelta(t, 2, position.Sog, 0.001)
+
+	motion.reset()
+	position = motion.update(ugps.GlobalPosition{Latitude: 61, Longitude: 10}, start.Add(3*time.Minute))
+	assert.Equal(t, 0.0, position.Sog)
+}
+
+func TestOutputterTargets(t *testing.T) {
+	targets := []outputTarget{
+		defaultTarget,
+		{endpoint: "/api/v1/position/global/2", number: 2, name: "ROV2"},
+		{endpoint: "/api/v1/position/global/3", number: 3, name: "ROV3"},
+		{endpoint: masterEndpoint, number: 4, name: "Topside"},
+	}
+	var buf bytes.Buffer
+	outputter := NewOutputter(newMovingUGPS(t), targets)
+	assert.Equal(t, []string{"/api/v1/position/global/2", "/api/v1/position/global/3"}, outputter.endpoints)
+	var serialisers nmeaSentences
+	for _, target := range targets {
+		serialisers = append(serialisers, tllSerialiser{}.withTarget(target))
+	}
+	outputter.addDestination(&buf, serialisers, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		outputter.OutputLoop(ctx)
+		close(done)
+	}()
+	select {
+	case <-outputter.outputStatusChannel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("No output status")
+	}
+	cancel()
+	<-done
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\r\n")
+	require.GreaterOrEqual(t, len(lines), 4)
+	assert.Contains(t, lines[0], "TLL,1,6324.00000,N,")
+	assert.Contains(t, lines[0], ",ROV,")
+	assert.Contains(t, lines[1], "TLL,2,6330.00000,N,01030.00000,E,ROV2,")
+	// The third target is not available in the UGPS and is sent as lost
+	assert.Contains(t, lines[2], "TLL,3,,,,,ROV3,")
+	assert.True(t, strings.HasSuffix(strings.Split(lines[2], "*")[0], ",L"))
+	assert.Contains(t, lines[3], "TLL,4,6324.00000,N,01024.00000,E,Topside,")
+}
+
+func TestSerialiserTalker(t *testing.T) {
+	fix := locatorFix{
+		global:  ugps.GlobalPosition{Latitude: 63.4, Longitude: 10.4, FixQuality: 1},
+		targets: map[string]ugps.GlobalPosition{locatorEndpoint: {Latitude: 63.4, Longitude: 10.4, FixQuality: 1}},
+	}
+	for _, serialiser := range []nmeaPositionSerialiser{ggaSerialiser{}, tllSerialiser{target: defaultTarget}, gllSerialiser{}, rmcSerialiser{}, gnsSerialiser{}, vtgSerialiser{}, ttmSerialiser{target: defaultTarget},
+		dptSerialiser{}, dbtSerialiser{}, xdrSerialiser{}} {
+		assert.True(t, strings.HasPrefix(serialiser.withTalker("GN").serialise(fix), "$GN"))
+		assert.True(t, strings.HasPrefix(serialiser.withTalker("GN").noPosition(), "$GN"))
+	}
+
+	assert.True(t, strings.HasPrefix(tllSerialiser{target: defaultTarget}.serialise(fix), "$RATLL,1,6324.00000,N,"))
+	assert.True(t, strings.HasPrefix(ggaSerialiser{}.withTalker("").serialise(fix), "$GPGGA,"))
+	assert.True(t, strings.HasPrefix(pwlDepSerialiser{}.withTalker("GN").serialise(fix), "$PWLDEP,"))
+	// A talker ID is rejected for outputs with only proprietary sentences
+	assert.Implements(t, (*proprietarySerialiser)(nil), pwlDepSerialiser{})
+	assert.Implements(t, (*proprietarySerialiser)(nil), pwlPosSerialiser{})
+	assert.NotImplements(t, (*proprietarySerialiser)(nil), ggaSerialiser{})
+}
+
+func TestTTMSerialiser(t *testing.T) {
+	locator := ugps.GlobalPosition{Latitude: 63.4, Longitude: 10.4, FixQuality: 1, Cog: 12.3, Sog: 0.5}
+	master := ugps.GlobalPosition{Latitude: 63.4, Longitude: 10.4, Orientation: 90}
+	// Another Locator 100 m north of the topside
+	other := ugps.GlobalPosition{Latitude: 63.4 + 100.0/earthRadius*180/math.Pi, Longitude: 10.4}
+	fix := locatorFix{
+		global: locator,
+		// The Locator is 30 m forward and 40 m to port of a vessel heading east
+		acoustic: ugps.AcousticPosition{X: 30, Y: -40, Z: 10},
+		master:   &master,
+		targets:  map[string]ugps.GlobalPosition{locatorEndpoint: locator, masterEndpoint: master, "/other": other},
+	}
+
+	sentence, err := nmea.Parse(ttmSerialiser{target: defaultTarget}.serialise(fix))
+	require.NoError(t, err)
+	ttm := sentence.(nmea.TTM)
+	assert.Equal(t, "RATTM", ttm.Prefix())
+	assert.Equal(t, int64(1), ttm.TargetNumber)
+	assert.Equal(t, "ROV", ttm.TargetName)
+	assert.InDelta(t, 50.0/1852, ttm.TargetDistance, 0.0001)
+	assert.InDelta(t, 90-53.13, ttm.Bearing, 0.1)
+	assert.Equal(t, "T", ttm.BearingType)
+	assert.Equal(t, 0.5, ttm.TargetSpeed)
+	assert.Equal(t, 12.3, ttm.TargetCourse)
+	assert.Equal(t, nmea.DistanceUnitNauticalMile, ttm.SpeedUnits)
+	assert.Equal(t, nmea.RadarTargetTracking, ttm.TargetStatus)
+
+	sentence, err = nmea.Parse(ttmSerialiser{target: outputTarget{endpoint: "/other", number: 2, name: "ROV2"}}.serialise(fix))
+	require.NoError(t, err)
+	ttm = sentence.(nmea.TTM)
+	assert.Equal(t, int64(2), ttm.TargetNumber)
+	assert.InDelta(t, 100.0/1852, ttm.TargetDistance, 0.0001)
+	assert.InDelta(t, 0, ttm.Bearing, 0.1)
+
+	sentence, err = nmea.Parse(ttmSerialiser{target: outputTarget{endpoint: "/lost", number: 3}}.serialise(fix))
+	require.NoError(t, err)
+	assert.Equal(t, nmea.RadarTargetLost, sentence.(nmea.TTM).TargetStatus)
+}
+
+func TestAISSerialiser(t *testing.T) {
+	locator := ugps.GlobalPosition{Latitude: 63.4, Longitude: 10.4, FixQuality: 1, Cog: 12.3, Sog: 0.5}
+	fix := locatorFix{global: locator, targets: map[string]ugps.GlobalPosition{locatorEndpoint: locator}}
+	target := outputTarget{endpoint: locatorEndpoint, number: 1, name: "ROV", mmsi: 970123456}
+	now := time.Date(2024, 5, 1, 12, 30, 17, 0, time.UTC)
+	settings := &aisSettings{messageType: aisPositionReportClassB, clock: func() time.Time { return now }}
+	serialiser := aisSerialiser{sentence: "VDM", settings: settings}.withTarget(target)
+
+	// The first position has the static data with the name
+	lines := strings.Split(serialiser.serialise(fix), "\r\n")
+	require.Len(t, lines, 3)
+	report := parseVDM(t, lines[0])
+	assert.Equal(t, "AIVDM", report.Prefix())
+	assert.Equal(t, uint64(aisPositionReportClassB), aisField(report.Payload, 0, 6))
+	assert.Equal(t, uint64(970123456), aisField(report.Payload, 8, 30))
+	assert.Equal(t, uint64(5), aisField(report.Payload, 46, 10))
+	assert.Equal(t, int64(6240000), aisSignedField(report.Payload, 57, 28))
+	assert.Equal(t, int64(38040000), aisSignedField(report.Payload, 85, 27))
+	assert.Equal(t, uint64(123), aisField(report.Payload, 112, 12))
+	// Carrier sense unit, display, DSC, whole band, message 22, assigned and RAIM flags
+	assert.Equal(t, []byte{1, 0, 0, 1, 0, 0, 0}, report.Payload[141:148])
+	static := parseVDM(t, lines[1])
+	assert.Equal(t, uint64(aisStaticDataReport), aisField(static.Payload, 0, 6))
+	assert.Equal(t, "ROV", strings.TrimRight(aisText(static.Payload, 40, 20), "@"))
+	assert.Equal(t, uint64(1), aisField(parseVDM(t, lines[2]).Payload, 38, 2))
+
+	// The static data is not sent again before aisStaticInterval
+	now = now.Add(aisStaticInterval - time.Second)
+	lines = strings.Split(serialiser.serialise(fix), "\r\n")
+	require.Len(t, lines, 1)
+	assert.Equal(t, uint64(now.Second()), aisField(parseVDM(t, lines[0]).Payload, 133, 6))
+
+	// and it is sent again after aisStaticInterval
+	now = now.Add(time.Second)
+	lines = strings.Split(serialiser.serialise(fix), "\r\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, uint64(aisStaticDataReport), aisField(parseVDM(t, lines[1]).Payload, 0, 6))
+
+	vdo := parseVDM(t, aisSerialiser{sentence: "VDO", settings: &aisSettings{messageType: aisPositionReportClassA}, target: target}.withTalker("BS").noPosition())
+	assert.Equal(t, "BSVDO", vdo.Prefix())
+	assert.Equal(t, uint64(aisPositionReportClassA), aisField(vdo.Payload, 0, 6))
+	assert.Equal(t, int64(aisLatNA*600000), aisSignedField(vdo.Payload, 89, 27))
+
+	// Targets which could not be fetched are sent without position
+	lost := aisSerialiser{sentence: "VDM", settings: &aisSettings{messageType: aisPositionReportClassB}}.withTarget(outputTarget{endpoint: "/lost", mmsi: 970123457})
+	report = parseVDM(t, lost.serialise(fix))
+	assert.Equal(t, int64(aisLonNA*600000), aisSignedField(report.Payload, 57, 28))
+}
+
+func TestDepthSerialisers(t *testing.T) {
+	fix := locatorFix{acoustic: ugps.AcousticPosition{X: 30, Y: -40, Z: 12.5}}
+	assert.Equal(t, "$SDDPT,12.50,0.0,*7D", dptSerialiser{}.serialise(fix))
+	assert.Equal(t, "$SDDBT,41.01,f,12.50,M,6.84,F*3E", dbtSerialiser{}.serialise(fix))
+	assert.Equal(t, "$YXXDR,D,12.50,M,LOCATOR*26", xdrSerialiser{}.serialise(fix))
+	assert.Contains(t, pwlDepSerialiser{}.serialise(fix), ",12.50,M,A*")
+	assert.Contains(t, pwlDepSerialiser{}.noPosition(), ",,M,V*")
+}
+
+func TestPWLPOSSerialiser(t *testing.T) {
+	fix := testFix()
+	fix.acoustic.PositionValid = true
+	// The course and speed over ground are from the UGPS, not computed by the bridge
+	fix.reported = fix.global
+	fix.reported.Cog, fix.reported.Sog, fix.reported.Orientation = 123.4, 1.5, 45
+	fix.global.Cog, fix.global.Sog = 270, 0.2
+	res := pwlPosSerialiser{}.withTalker("GN").serialise(fix)
+	assert.True(t, strings.HasPrefix(res, "$PWLPOS,"), res)
+	assert.Contains(t, res, ",6324.000000,N,01024.000000,W,2,9,0.8,123.4,1.50,45.0,30.00,-40.00,12.50,0.00,A,0*")
+
+	back, err := nmea.Parse(res)
+	require.NoError(t, err)
+	pos := back.(PWLPOSSentence)
+	assert.True(t, pos.Valid)
+	assert.Equal(t, fix.acoustic, pos.Acoustic)
+
+	assert.Contains(t, pwlPosSerialiser{}.noPosition(), ",V,0*")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	neturl "net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// serialParities are the supported values of the parity setting
+var serialParities = map[string]serial.Parity{
+	"none":  serial.NoParity,
+	"odd":   serial.OddParity,
+	"even":  serial.EvenParity,
+	"mark":  serial.MarkParity,
+	"space": serial.SpaceParity,
+}
+
+// serialStopBits are the supported values of the stopbits setting
+var serialStopBits = map[string]serial.StopBits{
+	"1":   serial.OneStopBit,
+	"1.5": serial.OnePointFiveStopBits,
+	"2":   serial.TwoStopBits,
+}
+
+// serialQueryKeys are the settings for a serial device
+var serialQueryKeys = []string{"baud", "databits", "parity", "stopbits", "rts", "dtr", "vid", "pid", "serial", "product"}
+
+// usbIdentity selects a USB serial adapter independent of the port name given by the OS.
+// Empty fields match all adapters.
+type usbIdentity struct {
+	vid          string
+	pid          string
+	serialNumber string
+	product      string
+}
+
+func (id usbIdentity) isSet() bool {
+	return id != usbIdentity{}
+}
+
+func (id usbIdentity) String() string {
+	var parts []string
+	for _, part := range []struct{ name, value string }{
+		{"vid", id.vid}, {"pid", id.pid}, {"serial", id.serialNumber}, {"product", id.product},
+	} {
+		if part.value != "" {
+			parts = append(parts, part.name+"="+part.value)
+		}
+	}
+	return "usb " + strings.Join(parts, " ")
+}
+
+// matches returns true if the port is a USB adapter with this identity.
+// The product string differs between operating systems and matches if it is part of the port product.
+func (id usbIdentity) matches(port *enumerator.PortDetails) bool {
+	return port.IsUSB &&
+		(id.vid == "" || strings.EqualFold(id.vid, port.VID)) &&
+		(id.pid == "" || strings.EqualFold(id.pid, port.PID)) &&
+		(id.serialNumber == "" || id.serialNumber == port.SerialNumber) &&
+		(id.product == "" || strings.Contains(strings.ToLower(port.Product), strings.ToLower(id.product)))
+}
+
+// findSerialPort returns the name of the only port matching the identity
+func findSerialPort(id usbIdentity, ports []*enumerator.PortDetails) (string, error) {
+	var names []string
+	for _, port := range ports {
+		if id.matches(port) {
+			names = append(names, port.Name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no serial port found for %s", id)
+	case 1:
+		return names[0], nil
+	}
+	return "", fmt.Errorf("several serial ports found for %s: %s", id, strings.Join(names, ", "))
+}
+
+// listSerialPorts writes the available serial ports with their USB identity
+func listSerialPorts(w io.Writer) error {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		fmt.Fprintln(w, "No serial ports found")
+		return nil
+	}
+	for _, port := range ports {
+		if !port.IsUSB {
+			fmt.Fprintf(w, "%s\n", port.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%s  vid: %s  pid: %s  serial: %s  product: %s\n", port.Name, port.VID, port.PID, port.SerialNumber, port.Product)
+		fmt.Fprintf(w, "  device: serial://?vid=%s&pid=%s&serial=%s\n", port.VID, port.PID, neturl.QueryEscape(port.SerialNumber))
+	}
+	return nil
+}
+
+// parseBaudrate parses the baudrate of a serial device
+func parseBaudrate(baud string) (int, error) {
+	b, err := strconv.Atoi(baud)
+	if err != nil || b <= 0 {
+		return 0, fmt.Errorf("unable to parse baudrate: %s as numeric value", baud)
+	}
+	return b, nil
+}
+
+// parseSerialQuery sets the serial port settings in the query of a serial device url
+// (baud, databits, parity, stopbits, rts and dtr)
+func parseSerialQuery(d *device, query neturl.Values) error {
+	for key := range query {
+		if !slices.Contains(serialQueryKeys, key) {
+			return fmt.Errorf("unknown serial setting '%s'. Supported are: %s", key, strings.Join(serialQueryKeys, ", "))
+		}
+	}
+
+	d.usb = usbIdentity{vid: query.Get("vid"), pid: query.Get("pid"), serialNumber: query.Get("serial"), product: query.Get("product")}
+	if d.usb.isSet() && d.address != "" {
+		return fmt.Errorf("use either a serial port or vid, pid, serial and product to select the port")
+	}
+	if !d.usb.isSet() && d.address == "" {
+		return fmt.Errorf("no serial port")
+	}
+
+	if baud := query.Get("baud"); baud != "" {
+		b, err := parseBaudrate(baud)
+		if err != nil {
+			return err
+		}
+		d.mode.BaudRate = b
+	}
+	if dataBits := query.Get("databits"); dataBits != "" {
+		bits, err := strconv.Atoi(dataBits)
+		if err != nil || bits < 5 || bits > 8 {
+			return fmt.Errorf("databits should be 5, 6, 7 or 8, got: %s", dataBits)
+		}
+		d.mode.DataBits = bits
+	}
+	if parity := query.Get("parity"); parity != "" {
+		p, exists := serialParities[strings.ToLower(parity)]
+		if !exists {
+			return fmt.Errorf("parity should be one of none, odd, even, mark, space, got: %s", parity)
+		}
+		d.mode.Parity = p
+	}
+	if stopBits := query.Get("stopbits"); stopBits != "" {
+		s, exists := serialStopBits[stopBits]
+		if !exists {
+			return fmt.Errorf("stopbits should be 1, 1.5 or 2, got: %s", stopBits)
+		}
+		d.mode.StopBits = s
+	}
+
+	// RTS and DTR are on by default when the port is opened
+	bits := serial.ModemOutputBits{RTS: true, DTR: true}
+	rts := strings.ToLower(query.Get("rts"))
+	switch rts {
+	case "", "on":
+	case "off":
+		bits.RTS = false
+	case "toggle":
+		// RTS is on while writing, used by RS-485 adapters to enable the transmitter
+		bits.RTS = false
+		d.rtsToggle = true
+	default:
+		return fmt.Errorf("rts should be on, off or toggle, got: %s", rts)
+	}
+	dtr := strings.ToLower(query.Get("dtr"))
+	switch dtr {
+	case "", "on":
+	case "off":
+		bits.DTR = false
+	default:
+		return fmt.Errorf("dtr should be on or off, got: %s", dtr)
+	}
+	if rts != "" || dtr != "" {
+		d.mode.InitialStatusBits = &bits
+	}
+	return nil
+}
+
+// rtsTogglePort sets RTS while writing and until the data is sent
+type rtsTogglePort struct {
+	serial.Port
+}
+
+func (p rtsTogglePort) Write(data []byte) (int, error) {
+	if err := p.SetRTS(true); err != nil {
+		return 0, err
+	}
+	n, err := p.Port.Write(data)
+	if err == nil {
+		err = p.Drain()
+	}
+	if rtsErr := p.SetRTS(false); err == nil {
+		err = rtsErr
+	}
+	return n, err
+}
+
+// openSerialPort opens the serial port with the settings of the device.
+// Ports selected by USB identity are looked up each time they are opened.
+func openSerialPort(d device) (serial.Port, error) {
+	name := d.address
+	if d.usb.isSet() {
+		ports, err := enumerator.GetDetailedPortsList()
+		if err != nil {
+			return nil, err
+		}
+		name, err = findSerialPort(d.usb, ports)
+		if err != nil {
+			return nil, err
+		}
+		debugPrintf("Using serial port %s for %s", name, d.usb)
+	}
+	mode := d.mode
+	port, err := serial.Open(name, &mode)
+	if err != nil {
+		return nil, err
+	}
+	if d.rtsToggle {
+		return rtsTogglePort{port}, nil
+	}
+	return port, nil
+}
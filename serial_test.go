@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// fakePort records the calls used when writing to a serial port
+type fakePort struct {
+	serial.Port
+	calls []string
+}
+
+func (p *fakePort) Write(data []byte) (int, error) {
+	p.calls = append(p.calls, fmt.Sprintf("write %s", data))
+	return len(data), nil
+}
+
+func (p *fakePort) Drain() error {
+	p.calls = append(p.calls, "drain")
+	return nil
+}
+
+func (p *fakePort) SetRTS(rts bool) error {
+	p.calls = append(p.calls, fmt.Sprintf("rts %v", rts))
+	return nil
+}
+
+func TestRTSTogglePort(t *testing.T) {
+	port := &fakePort{}
+	n, err := rtsTogglePort{port}.Write([]byte("$GPGGA"))
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, []string{"rts true", "write $GPGGA", "drain", "rts false"}, port.calls)
+}
+
+func TestFindSerialPort(t *testing.T) {
+	ports := []*enumerator.PortDetails{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A10K1", Product: "FT232R USB UART"},
+		{Name: "/dev/ttyUSB1", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "B20K2", Product: "FT232R USB UART"},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: "1546", PID: "01a8", Product: "u-blox GNSS receiver"},
+	}
+
+	name, err := findSerialPort(usbIdentity{vid: "0403", pid: "6001", serialNumber: "B20K2"}, ports)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/ttyUSB1", name)
+
+	name, err = findSerialPort(usbIdentity{vid: "1546", pid: "01A8"}, ports)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/ttyACM0", name)
+
+	name, err = findSerialPort(usbIdentity{product: "u-blox"}, ports)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/ttyACM0", name)
+
+	_, err = findSerialPort(usbIdentity{vid: "0403"}, ports)
+	assert.EqualError(t, err, "several serial ports found for usb vid=0403: /dev/ttyUSB0, /dev/ttyUSB1")
+
+	_, err = findSerialPort(usbIdentity{serialNumber: "C30K3"}, ports)
+	assert.EqualError(t, err, "no serial port found for usb serial=C30K3")
+}
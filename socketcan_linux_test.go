@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSocketCAN needs a virtual CAN interface:
+//
+//	ip link add dev vcan0 type vcan && ip link set up vcan0
+func TestSocketCAN(t *testing.T) {
+	if _, err := net.InterfaceByName("vcan0"); err != nil {
+		t.Skip("vcan0 not available")
+	}
+	reader, err := openSocketCAN("vcan0")
+	require.NoError(t, err)
+	defer reader.Close()
+	writer, err := openSocketCAN("vcan0")
+	require.NoError(t, err)
+	defer writer.Close()
+
+	pgns := newN2KPGNs([]n2kPGNSerialiser{n2kRapidPosition{}}, encodeSocketCAN)
+	require.NoError(t, pgns.writePosition(writer, testFix()))
+
+	reader.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Now().Add(time.Second))
+	frame := make([]byte, canFrameSize)
+	n, err := reader.Read(frame)
+	require.NoError(t, err)
+	assert.Equal(t, canFrameSize, n)
+	assert.Equal(t, uint32(0x8DF80164), binary.NativeEndian.Uint32(frame))
+	assert.Equal(t, int32(634000000), int32(binary.LittleEndian.Uint32(frame[8:])))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// openSocketCAN is only supported on Linux
+func openSocketCAN(iface string) (io.ReadWriteCloser, error) {
+	return nil, errors.New("SocketCAN is only supported on Linux")
+}
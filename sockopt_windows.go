@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// setBroadcast allows the socket to send to broadcast addresses
+func setBroadcast(fd uintptr) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.bug.st/serial"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	schemeSerial    = "serial"
+	schemeUDP       = "udp"
+	schemeTCP       = "tcp"
+	schemeTCPListen = "tcp-listen"
+	schemeSocketCAN = "socketcan"
+)
+
+const defaultBaudrate = 115200
+
+// tcpDialTimeout is the maximum time to wait for a TCP connection
+const tcpDialTimeout = 2 * time.Second
+
+// serialReadTimeout allows the input loop to detect cancellation when reading a serial port
+const serialReadTimeout = 500 * time.Millisecond
+
+// device is a parsed input or output device string
+type device struct {
+	scheme string
+	// address is host:port for network devices, the port name for serial devices
+	// and the interface name for SocketCAN devices
+	address string
+	// mode and rtsToggle are the serial port settings
+	mode      serial.Mode
+	rtsToggle bool
+	// usb selects the serial port by USB identity if set
+	usb usbIdentity
+	// ttl and iface are the multicast TTL and interface name for UDP devices
+	ttl   int
+	iface string
+}
+
+// parseDevice parses a device string. Supported formats are:
+//
+//	udp://host:port, tcp://host:port, tcp-listen://:port, serial:///dev/ttyUSB0?baud=4800, serial://?vid=0403&pid=6001,
+//	socketcan://can0
+//
+// and the formats from before URLs were supported, host:port for UDP and COM1@4800 for serial ports.
+func parseDevice(s string) (device, error) {
+	if !strings.Contains(s, "://") {
+		if strings.Contains(s, ":") {
+			return device{scheme: schemeUDP, address: s}, nil
+		}
+		port, baud, found := strings.Cut(s, "@")
+		d := newSerialDevice(port)
+		if found {
+			b, err := parseBaudrate(baud)
+			if err != nil {
+				return device{}, err
+			}
+			d.mode.BaudRate = b
+		}
+		return d, nil
+	}
+
+	u, err := neturl.Parse(s)
+	if err != nil {
+		return device{}, fmt.Errorf("unable to parse device '%s': %w", s, err)
+	}
+	switch u.Scheme {
+	case schemeUDP, schemeTCP, schemeTCPListen:
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return device{}, fmt.Errorf("device '%s' should be in form %s://host:port: %w", s, u.Scheme, err)
+		}
+		d := device{scheme: u.Scheme, address: u.Host}
+		if u.Scheme == schemeUDP {
+			d.iface = u.Query().Get("interface")
+			if ttl := u.Query().Get("ttl"); ttl != "" {
+				t, err := strconv.Atoi(ttl)
+				if err != nil || t < 1 || t > 255 {
+					return device{}, fmt.Errorf("ttl should be a number from 1 to 255, got: %s", ttl)
+				}
+				d.ttl = t
+			}
+		}
+		return d, nil
+	case schemeSerial:
+		d := newSerialDevice(u.Host + u.Path)
+		if err := parseSerialQuery(&d, u.Query()); err != nil {
+			return device{}, fmt.Errorf("device '%s': %w", s, err)
+		}
+		return d, nil
+	case schemeSocketCAN:
+		if u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return device{}, fmt.Errorf("device '%s' should be in form %s://interface", s, u.Scheme)
+		}
+		return device{scheme: u.Scheme, address: u.Host}, nil
+	}
+	return device{}, fmt.Errorf("unsupported device type '%s' in '%s'. Supported are: %s, %s, %s, %s, %s",
+		u.Scheme, s, schemeSerial, schemeUDP, schemeTCP, schemeTCPListen, schemeSocketCAN)
+}
+
+// newSerialDevice returns a serial device with the default settings
+func newSerialDevice(port string) device {
+	return device{scheme: schemeSerial, address: port, mode: serial.Mode{BaudRate: defaultBaudrate, DataBits: 8}}
+}
+
+// name returns the port name or address of the device
+func (d device) name() string {
+	if d.usb.isSet() {
+		return d.usb.String()
+	}
+	return d.address
+}
+
+func (d device) isNetwork() bool {
+	return d.scheme == schemeUDP || d.scheme == schemeTCP || d.scheme == schemeTCPListen
+}
+
+// tcpServer accepts TCP connections and writes to all connected clients
+type tcpServer struct {
+	ln net.Listener
+	// ctx is cancelled when the server is closed
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+	// acceptErr is the last error accepting clients, cleared when a client is accepted
+	acceptErr string
+}
+
+// newTCPServer listens on address and accepts clients until closed
+func newTCPServer(address string) (*tcpServer, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	srv := newTCPServerListener(ln)
+	go srv.acceptLoop()
+	return srv, nil
+}
+
+// newTCPServerListener returns a server for clients accepted from ln
+func newTCPServerListener(ln net.Listener) *tcpServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &tcpServer{ln: ln, ctx: ctx, cancel: cancel, clients: make(map[net.Conn]struct{})}
+}
+
+func (srv *tcpServer) acceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Eg. too many open files. Wait before accepting again instead of spinning.
+			delay = nextReconnectDelay(delay)
+			debugPrintf("TCP accept err: %v. Retrying in %v", err, delay)
+			srv.mu.Lock()
+			srv.acceptErr = err.Error()
+			srv.mu.Unlock()
+			select {
+			case <-srv.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		delay = 0
+		debugPrintf("Client connected: %s", conn.RemoteAddr())
+		srv.mu.Lock()
+		srv.clients[conn] = struct{}{}
+		srv.acceptErr = ""
+		srv.mu.Unlock()
+	}
+}
+
+// Write writes to all connected clients. Clients failing to receive are disconnected.
+func (srv *tcpServer) Write(p []byte) (int, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for conn := range srv.clients {
+		conn.SetWriteDeadline(time.Now().Add(connectionWriteTimeout))
+		if _, err := conn.Write(p); err != nil {
+			debugPrintf("Client disconnected: %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			delete(srv.clients, conn)
+		}
+	}
+	return len(p), nil
+}
+
+// clientCount returns the number of connected clients
+func (srv *tcpServer) clientCount() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.clients)
+}
+
+func (srv *tcpServer) connectionStatus() connectionStatus {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return connectionStatus{listening: true, clients: len(srv.clients), errMsg: srv.acceptErr}
+}
+
+// Addr returns the address the server is listening on
+func (srv *tcpServer) Addr() net.Addr {
+	return srv.ln.Addr()
+}
+
+func (srv *tcpServer) Close() error {
+	srv.cancel()
+	err := srv.ln.Close()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for conn := range srv.clients {
+		conn.Close()
+		delete(srv.clients, conn)
+	}
+	return err
+}
+
+// newInputConn returns a connection for reading from a serial, UDP, TCP client or SocketCAN device.
+// The device is reopened if it fails.
+func newInputConn(d device) *reconnectingConn {
+	open := func() (io.ReadWriteCloser, error) {
+		switch d.scheme {
+		case schemeSerial:
+			port, err := openSerialPort(d)
+			if err != nil {
+				return nil, err
+			}
+			port.SetReadTimeout(serialReadTimeout)
+			return port, nil
+		case schemeUDP:
+			return listenUDP(d)
+		case schemeTCP:
+			return net.DialTimeout("tcp", d.address, tcpDialTimeout)
+		case schemeSocketCAN:
+			return openSocketCAN(d.address)
+		}
+		return nil, fmt.Errorf("%s can not be used for reading", d.address)
+	}
+	return newReconnectingConn(d.name(), open)
+}
+
+// newOutputWriter opens a device for writing. Writes to SocketCAN devices must be one CAN frame.
+// All devices except TCP servers (tcp-listen) are reopened if they fail.
+func newOutputWriter(d device) (io.WriteCloser, error) {
+	if d.scheme == schemeTCPListen {
+		return newTCPServer(d.address)
+	}
+	open := func() (io.ReadWriteCloser, error) {
+		switch d.scheme {
+		case schemeSerial:
+			return openSerialPort(d)
+		case schemeUDP:
+			return dialUDP(d)
+		case schemeTCP:
+			return net.DialTimeout("tcp", d.address, tcpDialTimeout)
+		case schemeSocketCAN:
+			return openSocketCAN(d.address)
+		}
+		return nil, fmt.Errorf("%s can not be used for writing", d.address)
+	}
+	return newReconnectingConn(d.name(), open), nil
+}
+
+// dialUDP opens a UDP socket sending to a unicast, broadcast or multicast address
+func dialUDP(d device) (net.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", d.address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Control: func(network string, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = setBroadcast(fd) }); cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	conn, err := dialer.Dial("udp", udpAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	if !udpAddr.IP.IsMulticast() {
+		return conn, nil
+	}
+
+	if udpAddr.IP.To4() == nil {
+		conn.Close()
+		return nil, fmt.Errorf("only IPv4 multicast is supported, got %s", d.address)
+	}
+	p := ipv4.NewPacketConn(conn.(*net.UDPConn))
+	if d.ttl > 0 {
+		if err := p.SetMulticastTTL(d.ttl); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed setting multicast ttl: %w", err)
+		}
+	}
+	if d.iface != "" {
+		ifi, err := net.InterfaceByName(d.iface)
+		if err == nil {
+			err = p.SetMulticastInterface(ifi)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed setting multicast interface: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// listenUDP listens on a unicast address or joins a multicast group
+func listenUDP(d device) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", d.address)
+	if err != nil {
+		return nil, err
+	}
+	if !udpAddr.IP.IsMulticast() {
+		return net.ListenUDP("udp", udpAddr)
+	}
+
+	var ifi *net.Interface
+	if d.iface != "" {
+		ifi, err = net.InterfaceByName(d.iface)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return net.ListenMulticastUDP("udp", ifi, udpAddr)
+}
+
+// sleepContext waits for the duration or until the context is cancelled
+func sleepContext(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
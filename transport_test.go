@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected device
+	}{
+		{"127.0.0.1:2947", device{scheme: schemeUDP, address: "127.0.0.1:2947"}},
+		{"COM1", newSerialDevice("COM1")},
+		{"/dev/ttyUSB0@4800", device{scheme: schemeSerial, address: "/dev/ttyUSB0", mode: serial.Mode{BaudRate: 4800, DataBits: 8}}},
+		{"udp://:2948", device{scheme: schemeUDP, address: ":2948"}},
+		{"tcp://192.168.2.1:10110", device{scheme: schemeTCP, address: "192.168.2.1:10110"}},
+		{"tcp-listen://:10110", device{scheme: schemeTCPListen, address: ":10110"}},
+		{"socketcan://can0", device{scheme: schemeSocketCAN, address: "can0"}},
+		{"serial:///dev/ttyUSB0?baud=4800", device{scheme: schemeSerial, address: "/dev/ttyUSB0", mode: serial.Mode{BaudRate: 4800, DataBits: 8}}},
+		{"serial://COM7", newSerialDevice("COM7")},
+		{"serial://COM7?baud=9600&databits=7&parity=even&stopbits=2", device{scheme: schemeSerial, address: "COM7",
+			mode: serial.Mode{BaudRate: 9600, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.TwoStopBits}}},
+		{"serial:///dev/ttyUSB0?rts=toggle&dtr=off", device{scheme: schemeSerial, address: "/dev/ttyUSB0", rtsToggle: true,
+			mode: serial.Mode{BaudRate: defaultBaudrate, DataBits: 8, InitialStatusBits: &serial.ModemOutputBits{}}}},
+		{"serial://?vid=0403&pid=6001&serial=A10K1&baud=4800", device{scheme: schemeSerial,
+			usb:  usbIdentity{vid: "0403", pid: "6001", serialNumber: "A10K1"},
+			mode: serial.Mode{BaudRate: 4800, DataBits: 8}}},
+		{"serial://COM1?rts=off", device{scheme: schemeSerial, address: "COM1",
+			mode: serial.Mode{BaudRate: defaultBaudrate, DataBits: 8, InitialStatusBits: &serial.ModemOutputBits{DTR: true}}}},
+		{"udp://239.192.0.1:10110?ttl=4&interface=eth0", device{scheme: schemeUDP, address: "239.192.0.1:10110", ttl: 4, iface: "eth0"}},
+	}
+	for _, test := range tests {
+		d, err := parseDevice(test.input)
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, d, test.input)
+	}
+
+	for _, invalid := range []string{"COM1@fast", "ftp://host:21", "tcp://host", "serial://", "serial:///dev/ttyUSB0?baud=x", "udp://239.192.0.1:10110?ttl=256",
+		"COM1@0", "serial://COM1?databits=9", "serial://COM1?parity=x", "serial://COM1?stopbits=3",
+		"serial://COM1?rts=maybe", "serial://COM1?dtr=toggle", "serial://COM1?bauds=4800", "serial://COM1?vid=0403",
+		"socketcan://", "socketcan://can0/x"} {
+		_, err := parseDevice(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTCPServerFanOut(t *testing.T) {
+	srv, err := newTCPServer("127.0.0.1:0")
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Writing without clients is not an error
+	_, err = srv.Write([]byte("$GPGGA\r\n"))
+	require.NoError(t, err)
+
+	var readers []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		readers = append(readers, bufio.NewReader(conn))
+	}
+	require.Eventually(t, func() bool { return srv.clientCount() == 2 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, connectionStatus{listening: true, clients: 2}, srv.connectionStatus())
+
+	_, err = srv.Write([]byte("$RATLL\r\n"))
+	require.NoError(t, err)
+	for _, reader := range readers {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "$RATLL\r\n", line)
+	}
+}
+
+// failingListener is a listener where Accept fails as when the process is out of file descriptors
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int32
+}
+
+func (ln *failingListener) Accept() (net.Conn, error) {
+	ln.accepts.Add(1)
+	return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+}
+
+func (ln *failingListener) Close() error {
+	return nil
+}
+
+func (ln *failingListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10110}
+}
+
+func TestTCPServerAcceptError(t *testing.T) {
+	ln := &failingListener{}
+	srv := newTCPServerListener(ln)
+	done := make(chan struct{})
+	go func() {
+		srv.acceptLoop()
+		close(done)
+	}()
+
+	// Accept is retried after a delay instead of spinning
+	require.Eventually(t, func() bool { return ln.accepts.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), ln.accepts.Load())
+	status := srv.connectionStatus()
+	assert.Equal(t, 0, status.clients)
+	assert.Contains(t, status.String(), "listening, clients: 0, accept tcp")
+
+	// Closing stops the loop while it is waiting
+	srv.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("accept loop did not stop")
+	}
+}
+
+func TestTCPClientWriterReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	w, err := newOutputWriter(device{scheme: schemeTCP, address: ln.Addr().String()})
+	require.NoError(t, err)
+	defer w.Close()
+
+	_, err = w.Write([]byte("first\n"))
+	require.NoError(t, err)
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", line)
+
+	// The server drops the connection, the writer reconnects after the error
+	conn.Close()
+	require.Eventually(t, func() bool {
+		_, err := w.Write([]byte("lost\n"))
+		return err != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		_, err := w.Write([]byte("second\n"))
+		return err == nil
+	}, 3*time.Second, 100*time.Millisecond)
+	assert.Equal(t, connectionStatus{connected: true, reconnects: 1}, w.(*reconnectingConn).connectionStatus())
+	conn, err = ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	line, err = bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", line)
+}
+
+func TestDialUDPMulticast(t *testing.T) {
+	conn, err := dialUDP(device{scheme: schemeUDP, address: "239.192.0.1:10110", ttl: 4})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ttl, err := ipv4.NewPacketConn(conn.(*net.UDPConn)).MulticastTTL()
+	require.NoError(t, err)
+	assert.Equal(t, 4, ttl)
+
+	_, err = dialUDP(device{scheme: schemeUDP, address: "239.192.0.1:10110", iface: "does-not-exist"})
+	assert.Error(t, err)
+}
+
+func TestDialUDPBroadcast(t *testing.T) {
+	conn, err := dialUDP(device{scheme: schemeUDP, address: "255.255.255.255:10110"})
+	require.NoError(t, err)
+	conn.Close()
+}
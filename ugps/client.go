@@ -0,0 +1,199 @@
+/*
+Package ugps is a client for the Water Linked Underwater GPS REST API (/api/v1).
+
+Errors returned by the client can be inspected with errors.Is and errors.As:
+
+  - ErrNoPosition: the Underwater GPS has no position, typically because no Locator is detected
+  - *StatusError: the Underwater GPS responded with an unexpected HTTP status code
+  - *TransportError: the Underwater GPS could not be reached
+*/
+package ugps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout used for each request
+const DefaultTimeout = 1 * time.Second
+
+// ErrNoPosition is returned when the Underwater GPS has no position.
+// The Underwater GPS responds with status 500 if no Locator is detected.
+var ErrNoPosition = errors.New("Locator has no position?")
+
+// StatusError is returned when the Underwater GPS responds with an unexpected status code
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Expect status 200, got %d", e.StatusCode)
+}
+
+// TransportError is returned when the request could not be sent or the response not be read
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Client communicates with the Underwater GPS REST API
+type Client struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewClient creates a client for the Underwater GPS at baseURL (eg. http://192.168.2.94)
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+	}
+}
+
+// BaseURL returns the URL of the Underwater GPS
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// do sends the request with body encoded as json (if not nil) and decodes the response into target (if not nil)
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, target interface{}) error {
+	url := c.baseURL + path
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return &TransportError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Method: method, URL: url, StatusCode: resp.StatusCode}
+	}
+	if target == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return &TransportError{Err: fmt.Errorf("decoding response from %s: %w", url, err)}
+	}
+	return nil
+}
+
+// getPosition does a GET request, but reports status 500 as ErrNoPosition
+func (c *Client) getPosition(ctx context.Context, path string, target interface{}) error {
+	err := c.do(ctx, http.MethodGet, path, nil, target)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusInternalServerError {
+		return fmt.Errorf("%w %w", ErrNoPosition, err)
+	}
+	return err
+}
+
+// About returns the version information of the Underwater GPS
+func (c *Client) About(ctx context.Context) (About, error) {
+	var about About
+	err := c.do(ctx, http.MethodGet, "/api/v1/about", nil, &about)
+	return about, err
+}
+
+// Status returns the status of the Underwater GPS subsystems
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodGet, "/api/v1/about/status", nil, &status)
+	return status, err
+}
+
+// Config returns the generic configuration of the Underwater GPS
+func (c *Client) Config(ctx context.Context) (Config, error) {
+	var cfg Config
+	err := c.do(ctx, http.MethodGet, "/api/v1/config/generic", nil, &cfg)
+	return cfg, err
+}
+
+// SetConfig updates the generic configuration of the Underwater GPS
+func (c *Client) SetConfig(ctx context.Context, cfg Config) error {
+	return c.do(ctx, http.MethodPut, "/api/v1/config/generic", cfg, nil)
+}
+
+// GlobalPosition returns the global position of the Locator
+func (c *Client) GlobalPosition(ctx context.Context) (GlobalPosition, error) {
+	var pos GlobalPosition
+	err := c.getPosition(ctx, "/api/v1/position/global", &pos)
+	return pos, err
+}
+
+// MasterPosition returns the global position of the master (topside)
+func (c *Client) MasterPosition(ctx context.Context) (GlobalPosition, error) {
+	var pos GlobalPosition
+	err := c.getPosition(ctx, "/api/v1/position/master", &pos)
+	return pos, err
+}
+
+// Position returns the global position from the endpoint at path, eg. the position of another Locator
+func (c *Client) Position(ctx context.Context, path string) (GlobalPosition, error) {
+	var pos GlobalPosition
+	err := c.getPosition(ctx, path, &pos)
+	return pos, err
+}
+
+// AcousticPosition returns the filtered position of the Locator relative to the topside
+func (c *Client) AcousticPosition(ctx context.Context) (AcousticPosition, error) {
+	var pos AcousticPosition
+	err := c.getPosition(ctx, "/api/v1/position/acoustic/filtered", &pos)
+	return pos, err
+}
+
+// RawAcousticPosition returns the unfiltered position of the Locator relative to the topside
+func (c *Client) RawAcousticPosition(ctx context.Context) (AcousticPosition, error) {
+	var pos AcousticPosition
+	err := c.getPosition(ctx, "/api/v1/position/acoustic/raw", &pos)
+	return pos, err
+}
+
+// SetExternalDepth sends the depth of the Locator from an external depth sensor
+func (c *Client) SetExternalDepth(ctx context.Context, depth ExternalDepth) error {
+	return c.do(ctx, http.MethodPut, "/api/v1/external/depth", depth, nil)
+}
+
+// SetExternalMaster sends the position and heading of the topside from an external GPS/compass
+func (c *Client) SetExternalMaster(ctx context.Context, master ExternalMaster) error {
+	return c.do(ctx, http.MethodPut, "/api/v1/external/master", master, nil)
+}
+
+// SetExternalOrientation sends the heading of the topside from an external compass
+func (c *Client) SetExternalOrientation(ctx context.Context, orientation ExternalOrientation) error {
+	return c.do(ctx, http.MethodPut, "/api/v1/external/orientation", orientation, nil)
+}
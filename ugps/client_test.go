@@ -0,0 +1,214 @@
+package ugps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUGPS is a stand-in for the Underwater GPS REST API
+type fakeUGPS struct {
+	responses map[string]string
+	status    map[string]int
+	received  map[string]json.RawMessage
+}
+
+func newFakeUGPS(t *testing.T) (*fakeUGPS, *Client) {
+	fake := &fakeUGPS{
+		responses: make(map[string]string),
+		status:    make(map[string]int),
+		received:  make(map[string]json.RawMessage),
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, NewClient(srv.URL + "/")
+}
+
+func (f *fakeUGPS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.Path
+	if status, exists := f.status[key]; exists {
+		w.WriteHeader(status)
+		return
+	}
+	if r.Method == http.MethodPut {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.received[key] = body
+		return
+	}
+	response, exists := f.responses[key]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(response))
+}
+
+func TestGlobalPosition(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/position/global"] = `{"cog":1.5,"fix_quality":1,"hdop":0.8,"lat":63.4,"lon":10.4,"numsats":9,"orientation":42.1,"sog":0.3}`
+
+	pos, err := c.GlobalPosition(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, GlobalPosition{Latitude: 63.4, Longitude: 10.4, Cog: 1.5, FixQuality: 1, Hdop: 0.8, NumSats: 9, Orientation: 42.1, Sog: 0.3}, pos)
+}
+
+func TestMasterPosition(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/position/master"] = `{"lat":63.5,"lon":10.5,"orientation":270}`
+
+	pos, err := c.MasterPosition(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 63.5, pos.Latitude)
+	assert.Equal(t, 10.5, pos.Longitude)
+	assert.Equal(t, 270.0, pos.Orientation)
+}
+
+func TestPosition(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/position/global/2"] = `{"lat":63.6,"lon":10.6}`
+
+	pos, err := c.Position(context.Background(), "/api/v1/position/global/2")
+	require.NoError(t, err)
+	assert.Equal(t, 63.6, pos.Latitude)
+	assert.Equal(t, 10.6, pos.Longitude)
+}
+
+func TestAcousticPosition(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/position/acoustic/filtered"] = `{"position_valid":true,"std":0.2,"x":1.1,"y":-2.2,"z":3.3,"receiver_distance":[1,2,3,4]}`
+	fake.responses["GET /api/v1/position/acoustic/raw"] = `{"position_valid":false,"x":4,"y":5,"z":6}`
+
+	pos, err := c.AcousticPosition(context.Background())
+	require.NoError(t, err)
+	assert.True(t, pos.PositionValid)
+	assert.Equal(t, 1.1, pos.X)
+	assert.Equal(t, -2.2, pos.Y)
+	assert.Equal(t, 3.3, pos.Z)
+	assert.Equal(t, []float64{1, 2, 3, 4}, pos.ReceiverDistance)
+
+	raw, err := c.RawAcousticPosition(context.Background())
+	require.NoError(t, err)
+	assert.False(t, raw.PositionValid)
+	assert.Equal(t, 6.0, raw.Z)
+}
+
+func TestAboutConfigStatus(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/about"] = `{"chipid":"0xabc","version":"2.5.0","variant":"G2"}`
+	fake.responses["GET /api/v1/about/status"] = `{"gps":"ok","imu":"ok"}`
+	fake.responses["GET /api/v1/config/generic"] = `{"compass":"external","gps":"external","speed_of_sound":1475,"channel":3}`
+
+	about, err := c.About(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, About{ChipID: "0xabc", Version: "2.5.0", Variant: "G2"}, about)
+
+	status, err := c.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ok", status["gps"])
+
+	cfg, err := c.Config(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "external", cfg.Compass)
+	assert.Equal(t, "external", cfg.GPS)
+	assert.Equal(t, 1475.0, cfg.SpeedOfSound)
+	assert.Equal(t, 3, cfg.Channel)
+
+	cfg.Compass = "static"
+	require.NoError(t, c.SetConfig(context.Background(), cfg))
+	assert.Contains(t, string(fake.received["PUT /api/v1/config/generic"]), `"compass":"static"`)
+}
+
+func TestSetExternal(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+
+	err := c.SetExternalMaster(context.Background(), ExternalMaster{Lat: 1, Lon: 2, Orientation: 3})
+	require.NoError(t, err)
+	var master ExternalMaster
+	require.NoError(t, json.Unmarshal(fake.received["PUT /api/v1/external/master"], &master))
+	assert.Equal(t, ExternalMaster{Lat: 1, Lon: 2, Orientation: 3}, master)
+
+	err = c.SetExternalDepth(context.Background(), ExternalDepth{Depth: 12.5, Temperature: 4})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"depth":12.5,"temp":4}`, string(fake.received["PUT /api/v1/external/depth"]))
+
+	err = c.SetExternalOrientation(context.Background(), ExternalOrientation{Orientation: 90})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"orientation":90}`, string(fake.received["PUT /api/v1/external/orientation"]))
+}
+
+func TestErrNoPosition(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.status["GET /api/v1/position/global"] = http.StatusInternalServerError
+
+	_, err := c.GlobalPosition(context.Background())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNoPosition))
+	assert.Equal(t, "Locator has no position? Expect status 200, got 500", err.Error())
+
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusInternalServerError, statusErr.StatusCode)
+
+	var transportErr *TransportError
+	assert.False(t, errors.As(err, &transportErr))
+}
+
+func TestStatusError(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.status["PUT /api/v1/external/master"] = http.StatusInternalServerError
+
+	// 500 is only "no position" when fetching a position
+	err := c.SetExternalMaster(context.Background(), ExternalMaster{})
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNoPosition))
+
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.MethodPut, statusErr.Method)
+
+	_, err = c.About(context.Background())
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+}
+
+func TestTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	c := NewClient(srv.URL)
+	srv.Close()
+
+	_, err := c.GlobalPosition(context.Background())
+	require.Error(t, err)
+
+	var transportErr *TransportError
+	assert.True(t, errors.As(err, &transportErr))
+	assert.False(t, errors.Is(err, ErrNoPosition))
+}
+
+func TestTransportErrorInvalidJSON(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/position/global"] = `{"lat":`
+
+	_, err := c.GlobalPosition(context.Background())
+	var transportErr *TransportError
+	assert.True(t, errors.As(err, &transportErr))
+}
+
+func TestContextCancelled(t *testing.T) {
+	fake, c := newFakeUGPS(t)
+	fake.responses["GET /api/v1/position/global"] = `{}`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GlobalPosition(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
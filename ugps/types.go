@@ -0,0 +1,80 @@
+package ugps
+
+// GlobalPosition is a position in global coordinates, used for both the Locator and the master (topside)
+type GlobalPosition struct {
+	Latitude    float64 `json:"lat"`
+	Longitude   float64 `json:"lon"`
+	Cog         float64 `json:"cog"`
+	FixQuality  float64 `json:"fix_quality"`
+	Hdop        float64 `json:"hdop"`
+	NumSats     float64 `json:"numsats"`
+	Orientation float64 `json:"orientation"`
+	Sog         float64 `json:"sog"`
+}
+
+// AcousticPosition is the Locator position in meters relative to the topside antenna.
+// X is forward, Y is starboard and Z is depth.
+type AcousticPosition struct {
+	X                float64   `json:"x"`
+	Y                float64   `json:"y"`
+	Z                float64   `json:"z"`
+	Std              float64   `json:"std"`
+	PositionValid    bool      `json:"position_valid"`
+	ReceiverDistance []float64 `json:"receiver_distance,omitempty"`
+	ReceiverNsd      []float64 `json:"receiver_nsd,omitempty"`
+	ReceiverRssi     []float64 `json:"receiver_rssi,omitempty"`
+	ReceiverValid    []bool    `json:"receiver_valid,omitempty"`
+}
+
+// ExternalDepth is the depth of the Locator from an external depth sensor
+type ExternalDepth struct {
+	Depth       float64 `json:"depth"`
+	Temperature float64 `json:"temp"`
+}
+
+// ExternalMaster is the position and heading of the topside from an external GPS/compass.
+// Cog is course over ground in degrees and Sog is speed over ground in knots.
+type ExternalMaster struct {
+	Cog         float64 `json:"cog"`
+	FixQuality  float64 `json:"fix_quality"`
+	Hdop        float64 `json:"hdop"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	NumSats     float64 `json:"numsats"`
+	Orientation float64 `json:"orientation"`
+	Sog         float64 `json:"sog"`
+}
+
+// ExternalOrientation is the heading of the topside from an external compass
+type ExternalOrientation struct {
+	Orientation float64 `json:"orientation"`
+}
+
+// About describes the Underwater GPS
+type About struct {
+	ChipID  string `json:"chipid"`
+	Version string `json:"version"`
+	Variant string `json:"variant"`
+}
+
+// Config is the generic configuration of the Underwater GPS.
+// GPS and Compass is one of "onboard", "external" or "static".
+type Config struct {
+	CarrierFrequency  int     `json:"carrier_frequency"`
+	Channel           int     `json:"channel"`
+	Compass           string  `json:"compass"`
+	GPS               string  `json:"gps"`
+	RangeMaxX         float64 `json:"range_max_x"`
+	RangeMaxY         float64 `json:"range_max_y"`
+	RangeMaxZ         float64 `json:"range_max_z"`
+	RangeMinX         float64 `json:"range_min_x"`
+	RangeMinY         float64 `json:"range_min_y"`
+	SpeedOfSound      float64 `json:"speed_of_sound"`
+	StaticLat         float64 `json:"static_lat"`
+	StaticLon         float64 `json:"static_lon"`
+	StaticOrientation float64 `json:"static_orientation"`
+}
+
+// Status is the status of the Underwater GPS subsystems.
+// The available keys depend on the software version of the Underwater GPS.
+type Status map[string]any
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -10,8 +11,8 @@ import (
 	"github.com/gizak/termui/v3/widgets"
 )
 
-// RunUI updates the GUI
-func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outputStats, cfgSource string) {
+// RunUI updates the GUI until the user quits or the context is cancelled
+func RunUI(ctx context.Context, cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outputStats, cfgSource string) {
 	// Let the goroutines initialize before starting GUI
 	time.Sleep(50 * time.Millisecond)
 	if err := ui.Init(); err != nil {
@@ -33,7 +34,7 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 	p.BorderStyle.Fg = ui.ColorCyan
 
 	y += height
-	height = 10
+	height = 11
 	inSrcHeight := height
 	if cfg.RetransmitEnabled() {
 		inSrcHeight = height * 2
@@ -75,7 +76,26 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 
 	//y += height
 	y += inSrcHeight
+
+	depthStatus := widgets.NewParagraph()
+	if cfg.DepthEnabled() {
+		height = 7
+		depthStatus.Title = "External depth to UGPS"
+		depthStatus.Text = "Waiting for data"
+		depthStatus.SetRect(0, y, width, y+height)
+		depthStatus.TextStyle.Fg = ui.ColorGreen
+		depthStatus.BorderStyle.Fg = ui.ColorCyan
+		y += height
+	}
+
+	// Each output destination has its own status next to the UGPS status
+	outputs := cfg.EnabledOutputs()
 	height = 10
+	destHeight := height
+	if len(outputs) > 1 {
+		destHeight = 7
+		height = destHeight * len(outputs)
+	}
 
 	outSrcStatus := widgets.NewParagraph()
 	outSrcStatus.Title = "Locator Position in from UGPS"
@@ -92,15 +112,21 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 	outArrow.Text = "=>"
 	outArrow.SetRect(halfWidth, y, halfWidth+5, y+height)
 
-	outDestStatus := widgets.NewParagraph()
-	outDestStatus.Title = "Locator Position out to NMEA"
-	outDestStatus.Text = "Waiting for data"
-	if !cfg.OutputEnabled() {
-		outDestStatus.Text = "Output not enabled"
+	outDestStatus := make([]*widgets.Paragraph, max(len(outputs), 1))
+	for i := range outDestStatus {
+		outDestStatus[i] = widgets.NewParagraph()
+		outDestStatus[i].Title = "Locator Position out to NMEA"
+		if len(outputs) > 1 {
+			outDestStatus[i].Title += fmt.Sprintf(" (%d)", i+1)
+		}
+		outDestStatus[i].Text = "Waiting for data"
+		if !cfg.OutputEnabled() {
+			outDestStatus[i].Text = "Output not enabled"
+		}
+		outDestStatus[i].SetRect(halfWidth+5, y+i*destHeight, width, y+(i+1)*destHeight)
+		outDestStatus[i].TextStyle.Fg = ui.ColorGreen
+		outDestStatus[i].BorderStyle.Fg = ui.ColorCyan
 	}
-	outDestStatus.SetRect(halfWidth+5, y, width, y+height)
-	outDestStatus.TextStyle.Fg = ui.ColorGreen
-	outDestStatus.BorderStyle.Fg = ui.ColorCyan
 
 	y += height
 	height = 15
@@ -118,7 +144,10 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 	hideDebug.Border = false
 
 	draw := func() {
-		ui.Render(p, inpSrcStatus, inpArrow, inpDestStatus, outSrcStatus, outArrow, outDestStatus, inpRetransmitStatus)
+		ui.Render(p, inpSrcStatus, inpArrow, inpDestStatus, outSrcStatus, outArrow, inpRetransmitStatus, depthStatus)
+		for _, status := range outDestStatus {
+			ui.Render(status)
+		}
 		if debug {
 			dbgText.Rows = dbgMsg
 			ui.Render(dbgText)
@@ -134,12 +163,19 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case inStats := <-inStatusCh:
 			inpSrcStatus.TextStyle.Fg = ui.ColorGreen
-			inpSrcStatus.Text = fmt.Sprintf("Source: %s\n\n", cfg.Input.Device) +
+			connection := ""
+			if inStats.conn != (connectionStatus{}) {
+				connection = fmt.Sprintf(" (%s)", inStats.conn)
+			}
+			inpSrcStatus.Text = fmt.Sprintf("Source: %s%s\n\n", cfg.Input.Device, connection) +
 				"Supported NMEA sentences received:\n" +
 				fmt.Sprintf(" * Topside Position   : %s\n", inStats.src.posDesc) +
 				fmt.Sprintf(" * Topside Heading    : %s\n", inStats.src.headDesc) +
+				fmt.Sprintf(" * Topside Course     : %s (COG %.1f° SOG %.1f kn)\n", inStats.src.courseDesc, inStats.src.cog, inStats.src.sog) +
 				fmt.Sprintf(" * Parse error: %d\n\n", inStats.src.unparsableCount) +
 				inStats.src.errorMsg
 			if inStats.src.errorMsg != "" {
@@ -159,6 +195,14 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 			if inStats.retransmit.errorMsg != "" {
 				inpRetransmitStatus.TextStyle.Fg = ui.ColorRed
 			}
+
+			depthStatus.Text = fmt.Sprintf("Source: %s (offset %.2f m) Received: %s\n", cfg.Input.Device, cfg.Input.Depth.Offset, inStats.depth.srcDesc) +
+				fmt.Sprintf("Depth: %.2f m  Sent successfully to Underwater GPS: %d\n", inStats.depth.depth, inStats.depth.sendOk) +
+				inStats.depth.errorMsg
+			depthStatus.TextStyle.Fg = ui.ColorGreen
+			if inStats.depth.errorMsg != "" {
+				depthStatus.TextStyle.Fg = ui.ColorRed
+			}
 			draw()
 		case outStats := <-outputStatusChannel:
 			outSrcStatus.Text = fmt.Sprintf("Source: %s\n\n", cfg.BaseURL) +
@@ -170,14 +214,24 @@ func RunUI(cfg Config, inStatusCh chan inputStats, outputStatusChannel chan outp
 				outSrcStatus.Text += fmt.Sprintf("\n\n%v (%d)", outStats.src.errMsg, outStats.src.getErr)
 			}
 
-			outDestStatus.Text = fmt.Sprintf("Destination: %s\n\n", cfg.Output.Device) +
-				"Sent:\n" +
-				fmt.Sprintf(" * Locator/ROV Position : %s: %d\n", strings.ToUpper(cfg.Output.PositionSentence), outStats.dst.sendOk)
-			outDestStatus.TextStyle.Fg = ui.ColorGreen
-
-			if outStats.dst.errMsg != "" {
-				outDestStatus.TextStyle.Fg = ui.ColorRed
-				outDestStatus.Text += fmt.Sprintf("\n\n%s", outStats.dst.errMsg)
+			for i, dst := range outStats.dst {
+				rate := ""
+				if outputs[i].Rate > 0 {
+					rate = fmt.Sprintf(" (max %g Hz)", outputs[i].Rate)
+				}
+				connection := ""
+				if dst.conn != (connectionStatus{}) {
+					connection = fmt.Sprintf(" (%s)", dst.conn)
+				}
+				outDestStatus[i].Text = fmt.Sprintf("Destination: %s%s\n", outputs[i].Device, connection) +
+					fmt.Sprintf("Sentences: %s%s\n", strings.ToUpper(strings.Join(outputs[i].PositionSentences(), ", ")), rate) +
+					fmt.Sprintf("Locator/ROV positions sent: %d\n", dst.sendOk)
+				outDestStatus[i].TextStyle.Fg = ui.ColorGreen
+
+				if dst.errMsg != "" {
+					outDestStatus[i].TextStyle.Fg = ui.ColorRed
+					outDestStatus[i].Text += dst.errMsg
+				}
 			}
 			draw()
 		case e := <-uiEvents:
@@ -0,0 +1,117 @@
+/*
+Package wmm calculates the magnetic declination using the World Magnetic Model (WMM).
+
+The declination is the angle between true north and magnetic north, east positive.
+A magnetic heading is converted to a true heading by adding the declination.
+*/
+package wmm
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// maxDegree of the spherical harmonic model
+	maxDegree = 12
+	// geomagneticRadius is the geomagnetic reference radius in km
+	geomagneticRadius = 6371.2
+	// WGS-84 ellipsoid
+	semiMajorAxis = 6378.137
+	flattening    = 1 / 298.257223563
+)
+
+// decimalYear converts t to years, eg. 2025.5 for the middle of 2025
+func decimalYear(t time.Time) float64 {
+	t = t.UTC()
+	start := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	return float64(t.Year()) + t.Sub(start).Seconds()/end.Sub(start).Seconds()
+}
+
+// Declination returns the magnetic declination in degrees (east positive) at the
+// geodetic latitude/longitude in degrees and height above the ellipsoid in meters at time t.
+func Declination(latitude float64, longitude float64, height float64, t time.Time) float64 {
+	north, east, _ := Field(latitude, longitude, height, t)
+	return math.Atan2(east, north) * 180 / math.Pi
+}
+
+// Field returns the north, east and down components of the main magnetic field in nT.
+// See Declination for the arguments.
+func Field(latitude float64, longitude float64, height float64, t time.Time) (float64, float64, float64) {
+	dt := decimalYear(t) - epoch
+
+	// Geodetic to geocentric spherical coordinates
+	lat := latitude * math.Pi / 180
+	lon := longitude * math.Pi / 180
+	h := height / 1000
+	e2 := flattening * (2 - flattening)
+	sinLat := math.Sin(lat)
+	rc := semiMajorAxis / math.Sqrt(1-e2*sinLat*sinLat)
+	p := (rc + h) * math.Cos(lat)
+	z := (rc*(1-e2) + h) * sinLat
+	r := math.Hypot(p, z)
+	latSpherical := math.Asin(z / r)
+
+	// Schmidt semi-normalised associated Legendre functions and their derivatives
+	// with respect to colatitude theta
+	cosTheta := math.Sin(latSpherical)
+	sinTheta := math.Cos(latSpherical)
+	var pnm, dpnm [maxDegree + 1][maxDegree + 1]float64
+	pnm[0][0] = 1
+	for n := 1; n <= maxDegree; n++ {
+		nf := float64(n)
+		for m := 0; m <= n; m++ {
+			mf := float64(m)
+			switch {
+			case n == m && n == 1:
+				pnm[1][1] = sinTheta
+				dpnm[1][1] = cosTheta
+			case n == m:
+				k := math.Sqrt((2*nf - 1) / (2 * nf))
+				pnm[n][n] = k * sinTheta * pnm[n-1][n-1]
+				dpnm[n][n] = k * (cosTheta*pnm[n-1][n-1] + sinTheta*dpnm[n-1][n-1])
+			default:
+				var p2, dp2 float64
+				if n >= 2 {
+					p2 = pnm[n-2][m]
+					dp2 = dpnm[n-2][m]
+				}
+				k1 := 2*nf - 1
+				k2 := math.Sqrt((nf-1)*(nf-1) - mf*mf)
+				k3 := math.Sqrt(nf*nf - mf*mf)
+				pnm[n][m] = (k1*cosTheta*pnm[n-1][m] - k2*p2) / k3
+				dpnm[n][m] = (k1*(cosTheta*dpnm[n-1][m]-sinTheta*pnm[n-1][m]) - k2*dp2) / k3
+			}
+		}
+	}
+
+	// Sum the spherical harmonics
+	var x, y, zz float64
+	ratio := geomagneticRadius / r
+	for _, c := range coefficients {
+		n := int(c[0])
+		m := int(c[1])
+		g := c[2] + dt*c[4]
+		hh := c[3] + dt*c[5]
+		scale := math.Pow(ratio, float64(n+2))
+		cosM := math.Cos(float64(m) * lon)
+		sinM := math.Sin(float64(m) * lon)
+
+		x += scale * (g*cosM + hh*sinM) * dpnm[n][m]
+		y += scale * float64(m) * (g*sinM - hh*cosM) * pnm[n][m]
+		zz -= scale * float64(n+1) * (g*cosM + hh*sinM) * pnm[n][m]
+	}
+	if sinTheta > 1e-10 {
+		y /= sinTheta
+	} else {
+		// At the geographic poles the east component is undefined
+		y = 0
+	}
+
+	// Rotate from geocentric to geodetic
+	psi := latSpherical - lat
+	north := x*math.Cos(psi) - zz*math.Sin(psi)
+	down := x*math.Sin(psi) + zz*math.Cos(psi)
+	return north, y, down
+}
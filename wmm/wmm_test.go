@@ -0,0 +1,45 @@
+package wmm
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimalYear(t *testing.T) {
+	assert.Equal(t, 2025.0, decimalYear(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.InDelta(t, 2025.5, decimalYear(time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)), 0.001)
+}
+
+func TestDeclination(t *testing.T) {
+	// Declination at some well known locations mid 2025
+	at := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		lat, lon float64
+		expected float64
+	}{
+		{"Boulder", 40.0, -105.25, 7.8},
+		{"London", 51.5, -0.12, 1.0},
+		{"Cape Town", -33.9, 18.4, -26.5},
+		{"Tokyo", 35.7, 139.7, -7.9},
+	}
+	for _, test := range tests {
+		assert.InDelta(t, test.expected, Declination(test.lat, test.lon, 0, at), 0.5, test.name)
+	}
+}
+
+func TestField(t *testing.T) {
+	// Total intensity is between 20000 and 70000 nT everywhere on earth
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for lat := -89.0; lat <= 89; lat += 10 {
+		for lon := -180.0; lon <= 180; lon += 30 {
+			north, east, down := Field(lat, lon, 0, at)
+			total := math.Sqrt(north*north + east*east + down*down)
+			assert.Greater(t, total, 20000.0)
+			assert.Less(t, total, 70000.0)
+		}
+	}
+}